@@ -27,7 +27,7 @@ dcm() {
       ;;
     "unload" | "ul" )
       unset -f dcm > /dev/null 2>&1
-      unset DCM_DIR DCM_PROJECT > /dev/null 2>&1
+      unset DCM_DIR DCM_PROJECT DCM_SRV > /dev/null 2>&1
       ;;
     * )
       $BIN ${@}
@@ -42,18 +42,21 @@ _dcm_complete() {
 
   case $COMP_CWORD in
     1)
-      use="help setup run build shell purge branch goto update unload"
+      use="help init project validate setup run build shell purge branch goto update list status config render version unload"
       ;;
     2)
       local prev_word=${COMP_WORDS[1]}
       case $prev_word in
         run|r)
-          use="execute init build start stop restart up"
+          use="execute wait init build start stop restart up"
           ;;
         purge|rm)
           use="images containers all"
           ;;
-        shell|sh|branch|br|goto|gt|cd|update|u)
+        project)
+          use="list current use create"
+          ;;
+        shell|sh|branch|br|goto|gt|cd|update|u|status|st|config)
           use=`dcm list`
           ;;
       esac
@@ -10,6 +10,7 @@ import (
 
 type Executable interface {
 	Exec(string, ...string) Executable
+	Clone() Executable
 	Setcmd(*exec.Cmd) Executable
 	SetStdin(io.Reader) Executable
 	SetStdout(io.Writer) Executable
@@ -23,6 +24,15 @@ type Executable interface {
 	FormatError(error, []byte) error
 }
 
+// getExitCode returns the exit code of a command that failed, or 1 when it
+// could not run at all
+func getExitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
 type Cmd struct {
 	name           string
 	args           []string
@@ -46,6 +56,14 @@ func (c *Cmd) Exec(name string, args ...string) Executable {
 	return c
 }
 
+func (c *Cmd) Clone() Executable {
+	return &Cmd{
+		stdin:  c.stdin,
+		stdout: c.stdout,
+		stderr: c.stderr,
+	}
+}
+
 func (c *Cmd) Setcmd(cmd *exec.Cmd) Executable {
 	c.cmd = cmd
 	return c
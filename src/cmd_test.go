@@ -72,6 +72,16 @@ func TestCmdExec(t *testing.T) {
 	assert.Equal(t, "*exec.Cmd", reflect.TypeOf(c.cmd).String())
 }
 
+func TestCmdClone(t *testing.T) {
+	var out bytes.Buffer
+
+	c := NewCmd().SetStdout(&out).Exec("echo", "foo")
+	clone := c.Clone()
+
+	assert.NotEqual(t, fmt.Sprintf("%p", c), fmt.Sprintf("%p", clone))
+	assert.Equal(t, &Cmd{stdin: os.Stdin, stdout: &out, stderr: os.Stderr}, clone)
+}
+
 func TestCmdSetStdin(t *testing.T) {
 	c := &Cmd{}
 	c.SetStdin(os.Stdin)
@@ -144,3 +154,12 @@ func TestCmdFormatError(t *testing.T) {
 
 	assert.Equal(t, errors.New("foobar: bazqux"), c.FormatError(err, out))
 }
+
+func TestGetExitCode(t *testing.T) {
+	// The helper process exits with 2 on unknown commands
+	err := NewCmd().Setcmd(helperCommand(t, "exit")).Run()
+	assert.Equal(t, 2, getExitCode(err))
+
+	err = NewCmd().Exec("dcm-test-missing-binary").Run()
+	assert.Equal(t, 1, getExitCode(err))
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type command struct {
+	Name    string
+	Aliases []string
+	Args    string
+	Help    []string
+
+	// Whether the config file is loaded and validated before running
+	// the command
+	LoadConfig, Validate bool
+
+	// Whether the command takes the service selectors
+	Select bool
+
+	Run func(d *Dcm, args []string) (int, error)
+}
+
+type option struct {
+	Flags string
+	Help  []string
+}
+
+// commands lists the commands in the order they are shown in the help menu
+var commands []*command
+
+// options lists the global options, parsed before the command name
+var options = []option{
+	{"-j, --jobs <n>", []string{
+		"Process up to <n> services in parallel, respecting the",
+		"dependency order. Defaults to the jobs project setting,",
+		"or 1.",
+	}},
+	{"-k, --keep-going", []string{
+		"Keep processing the other services when one fails, and",
+		"report all the failures at the end.",
+	}},
+	{"--project <name>", []string{
+		"Name of the project. Defaults to $DCM_PROJECT, or dcm.",
+	}},
+	{"--dir <dir>", []string{
+		"Folder of the project. Defaults to $DCM_DIR, or the first",
+		"folder from the working directory up with a .dcmrc file",
+		"or the config file of the project.",
+	}},
+	{"--config <file>", []string{
+		"Config file to load instead of <dir>/<project>.yml and",
+		"its override files. Repeat it to merge several files,",
+		"the later ones overriding the earlier ones.",
+	}},
+	{"--compose <binary>", []string{
+		"Compose command, as in \"docker compose\". Defaults to the",
+		"compose_binary project setting, or the first one found of",
+		"docker-compose and docker compose.",
+	}},
+	{"--srv <dir>", []string{
+		"Folder of the service repos. Defaults to $DCM_SRV, the srv",
+		"project setting, or <dir>/srv/<project>.",
+	}},
+}
+
+// selectors lists the options picking the services a command acts on, given
+// after the command name. The services they depend on are always included.
+var selectors = []option{
+	{"<service>...", []string{
+		"The given services.",
+	}},
+	{"-g, --group <group>", []string{
+		"The services of the group, from the dcm.groups labels or",
+		"the groups project setting.",
+	}},
+	{"-l, --label <key=value>", []string{
+		"The services with the label, or with the label set at all",
+		"when only <key> is given.",
+	}},
+	{"-x, --except <name>", []string{
+		"Leave out the service, or the services of the group, even",
+		"when other services depend on it.",
+	}},
+}
+
+func init() {
+	commands = []*command{
+		{
+			Name:    "help",
+			Aliases: []string{"h"},
+			Args:    "[<command>]",
+			Help: []string{
+				"Show this help menu, or the help of the given command.",
+			},
+			Run: func(d *Dcm, args []string) (int, error) {
+				if len(args) > 0 {
+					if c := getCommand(args[0]); c != nil {
+						c.Usage(d.Stdout)
+						return 0, nil
+					}
+				}
+				d.Usage()
+				return 0, nil
+			},
+		},
+		{
+			Name: "init",
+			Args: "[--from <file>]",
+			Help: []string{
+				"Create the config file of the project from a compose file,",
+				"<dir>/docker-compose.yml by default, and from the git repos",
+				"already in the srv folder. It never overwrites the file.",
+			},
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Init(args...)
+			},
+		},
+		{
+			Name: "validate",
+			Help: []string{
+				"Check the config file for unknown or invalid dcm labels,",
+				"services without image, build or repository, and missing",
+				"init scripts. It's also run before setup, run and build.",
+			},
+			LoadConfig: true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Validate()
+			},
+		},
+		{
+			Name: "setup",
+			Help: []string{
+				"Git checkout repositories for the services that require",
+				"local docker build. It skips the service when the image",
+				"is from docker hub, or the repo's folder already exists.",
+			},
+			Args:       "[<selectors>]",
+			LoadConfig: true,
+			Validate:   true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				if _, err := d.setSelector(args, 0); err != nil {
+					return 1, err
+				}
+				return d.Setup()
+			},
+		},
+		{
+			Name:    "run",
+			Aliases: []string{"r"},
+			Args:    "[<args>] [<selectors>]",
+			Help: []string{
+				"Run docker-compose commands. If <args> is not given, by",
+				"default DCM will run `docker-compose up` command.",
+				"<args>: up, build, start, stop, restart, pre-init, wait, init,",
+				"execute",
+			},
+			LoadConfig: true,
+			Validate:   true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				// The arguments of execute are passed on to compose as is
+				if len(args) > 0 && args[0] == "execute" {
+					return d.Run(args...)
+				}
+				args, err := d.setSubcommandSelector(args, runCommands)
+				if err != nil {
+					return 1, err
+				}
+				return d.Run(args...)
+			},
+		},
+		{
+			Name:    "build",
+			Aliases: []string{"b"},
+			Help: []string{
+				"Docker (re)build service images that require local build.",
+				"It's the shorthand version of `dcm run build` command.",
+			},
+			Args:       "[<selectors>]",
+			LoadConfig: true,
+			Validate:   true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				if _, err := d.setSelector(args, 0); err != nil {
+					return 1, err
+				}
+				return d.Run("build")
+			},
+		},
+		{
+			Name:    "shell",
+			Aliases: []string{"sh"},
+			Args:    "[<service>] [--index <n>]",
+			Help: []string{
+				"Log into a given service container. If <service> is not",
+				"given, DCM uses the service whose repo you are in.",
+				"--index <n>: log into the replica <n> of the service",
+				"rather than the first one running.",
+			},
+			LoadConfig: true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Shell(args...)
+			},
+		},
+		{
+			Name:    "purge",
+			Aliases: []string{"rm"},
+			Args:    "[<type>] [<selectors>]",
+			Help: []string{
+				"Remove either all the containers or all the images. If <type>",
+				"is not given, by default DCM will purge the containers.",
+				"<type>: images, containers, all",
+			},
+			LoadConfig: true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				args, err := d.setSubcommandSelector(args, purgeTypes)
+				if err != nil {
+					return 1, err
+				}
+				return d.Purge(args...)
+			},
+		},
+		{
+			Name:    "branch",
+			Aliases: []string{"br"},
+			Args:    "[--output <format>] [<selectors>]",
+			Help: []string{
+				"Display the current git branch for the given services that",
+				"were built locally. If no service is given, DCM uses the",
+				"service whose repo you are in, or all the services.",
+				"--output, -o <format>: text (default), table, json, yaml",
+			},
+			LoadConfig: true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Branch(args...)
+			},
+		},
+		{
+			// The shell function from dcm.sh changes the directory to the
+			// one printed by the dir alias
+			Name:    "goto",
+			Aliases: []string{"gt", "cd", "dir"},
+			Args:    "[--output <format>] [<service>]",
+			Help: []string{
+				"Go to the service's folder. If <service> is not given, by",
+				"default DCM will go to $DCM_DIR. `dcm dir` prints the folder",
+				"instead.",
+				"--output, -o <format>: text (default), table, json, yaml",
+			},
+			LoadConfig: true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Dir(args...)
+			},
+		},
+		{
+			Name: "update",
+			Args: "[<selectors>]",
+			Help: []string{
+				"Update DCM and(or) the given services. If no service is",
+				"given, DCM uses the service whose repo you are in, or all",
+				"the services.",
+			},
+			LoadConfig: true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				args, err := d.setSelector(args, -1)
+				if err != nil {
+					return 1, err
+				}
+				return d.Update(args...)
+			},
+		},
+		{
+			Name:    "list",
+			Aliases: []string{"ls"},
+			Args:    "[--output <format>] [<selectors>]",
+			Help: []string{
+				"List all the available services, or the selected ones.",
+				"--output, -o <format>: text (default), table, json, yaml",
+			},
+			LoadConfig: true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.List(args...)
+			},
+		},
+		{
+			Name:    "status",
+			Aliases: []string{"st"},
+			Args:    "[--output <format>] [<selectors>]",
+			Help: []string{
+				"Show the state of the services: where they come from, the",
+				"branch, changes and last commit of their checkout, and the",
+				"state, health, uptime and ports of their containers.",
+				"--output, -o <format>: table (default), json, yaml",
+			},
+			LoadConfig: true,
+			Select:     true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Status(args...)
+			},
+		},
+		{
+			Name: "project",
+			Args: "[<command>]",
+			Help: []string{
+				"Manage the projects of $DCM_DIR, one for each config file.",
+				"<command>: list (default), current, use <name>,",
+				"create <name> [--from <project>]",
+				"--output, -o <format>: text (default), table, json, yaml,",
+				"for list and current.",
+				"`use` makes <name> the project used without --project or",
+				"$DCM_PROJECT, `create` copies the config file of the current",
+				"project, or of the given one, to a new instance.",
+			},
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Project(args...)
+			},
+		},
+		{
+			Name: "config",
+			Args: "[<options>] [<service>]",
+			Help: []string{
+				"Print the resolved config of the project, or of the given",
+				"service, after merging the override files and replacing",
+				"the variables.",
+				"--output, -o <format>: yaml (default), json",
+				"--show-origin: also tell the option, environment variable",
+				"or file line each value comes from.",
+			},
+			LoadConfig: true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.ShowConfig(args...)
+			},
+		},
+		{
+			Name: "render",
+			Help: []string{
+				"Render the compose file handed to compose, in",
+				"<dir>/.dcm/<project>/docker-compose.yml, and print it.",
+				"Relative paths are resolved and the dcm labels left out.",
+			},
+			LoadConfig: true,
+			Run: func(d *Dcm, args []string) (int, error) {
+				return d.Render(args...)
+			},
+		},
+		{
+			Name: "version",
+			Help: []string{
+				"Show the version of DCM.",
+			},
+			Run: func(d *Dcm, args []string) (int, error) {
+				fmt.Fprintln(d.Stdout, "DCM version", Version)
+				return 0, nil
+			},
+		},
+	}
+}
+
+func getCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+		for _, alias := range c.Aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Usage prints the help of the command, as shown by `dcm <command> --help`
+func (c *command) Usage(w io.Writer) {
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  "+strings.TrimSpace("dcm [<options>] "+c.Name+" "+c.Args))
+	fmt.Fprintln(w, "")
+	for _, line := range c.Help {
+		fmt.Fprintln(w, "  "+line)
+	}
+	if c.Select {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "Selectors:")
+		for _, o := range selectors {
+			printHelpEntry(w, o.Flags, o.Help)
+		}
+	}
+	if len(c.Aliases) > 0 {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "Aliases:")
+		fmt.Fprintln(w, "  "+strings.Join(c.Aliases, ", "))
+	}
+	fmt.Fprintln(w, "")
+}
+
+func (d *Dcm) Usage() {
+	w := d.Stdout
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "DCM (Docker-Compose Manager)")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  dcm [<options>] <command> [<args>]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Options:")
+	for _, o := range options {
+		printHelpEntry(w, o.Flags, o.Help)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		printHelpEntry(w, strings.TrimSpace("dcm "+c.Name+" "+c.Args), c.Help)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Selectors:")
+	for _, o := range selectors {
+		printHelpEntry(w, o.Flags, o.Help)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Run `dcm <command> --help` for more information on a command.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Example:")
+	fmt.Fprintln(w, "  Initial setup")
+	fmt.Fprintln(w, "    dcm setup")
+	fmt.Fprintln(w, "    dcm run")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Rebuild")
+	fmt.Fprintln(w, "    dcm build")
+	fmt.Fprintln(w, "    dcm run")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Or only Rerun")
+	fmt.Fprintln(w, "    dcm run")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Log into a service's container")
+	fmt.Fprintln(w, "    dcm shell service_name")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Run the billing services, and the services they depend on")
+	fmt.Fprintln(w, "    dcm run --group billing")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  Run another instance of the project")
+	fmt.Fprintln(w, "    dcm --project project2 run")
+	fmt.Fprintln(w, "")
+}
+
+// printHelpEntry prints the name in the first column and the help lines in
+// the second one, starting on the next line when the name is too long.
+func printHelpEntry(w io.Writer, name string, help []string) {
+	const width = 22
+
+	if len(name) > width {
+		fmt.Fprintln(w, "  "+name)
+	} else if len(help) > 0 {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, name, help[0])
+		help = help[1:]
+	}
+	for _, line := range help {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, "", line)
+	}
+}
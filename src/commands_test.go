@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCommand(t *testing.T) {
+	assert.Equal(t, "run", getCommand("run").Name)
+	assert.Equal(t, "run", getCommand("r").Name)
+	assert.Equal(t, "goto", getCommand("dir").Name)
+	assert.Nil(t, getCommand("invalid"))
+
+	// Names and aliases must never clash
+	seen := map[string]bool{}
+	for _, c := range commands {
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			assert.False(t, seen[name], "[%s] Command name used twice", name)
+			seen[name] = true
+		}
+		assert.NotEmpty(t, c.Help, "[%s] Command without help", c.Name)
+		assert.NotNil(t, c.Run, "[%s] Command without run function", c.Name)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var out bytes.Buffer
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = &out
+	dcm.Usage()
+
+	assert.Contains(t, out.String(), "DCM (Docker-Compose Manager)\n")
+	assert.Contains(t, out.String(), "Usage:\n")
+	assert.Contains(t, out.String(), "Example:\n")
+}
+
+func TestCommandUsage(t *testing.T) {
+	var out bytes.Buffer
+	getCommand("goto").Usage(&out)
+	assert.Equal(t, "\n"+
+		"Usage:\n"+
+		"  dcm [<options>] goto [--output <format>] [<service>]\n"+
+		"\n"+
+		"  Go to the service's folder. If <service> is not given, by\n"+
+		"  default DCM will go to $DCM_DIR. `dcm dir` prints the folder\n"+
+		"  instead.\n"+
+		"  --output, -o <format>: text (default), table, json, yaml\n"+
+		"\n"+
+		"Aliases:\n"+
+		"  gt, cd, dir\n"+
+		"\n", out.String())
+
+	out.Reset()
+	getCommand("setup").Usage(&out)
+	assert.NotContains(t, out.String(), "Aliases:")
+	assert.Contains(t, out.String(), "Selectors:\n  <service>...            The given services.\n")
+}
+
+func TestPrintHelpEntry(t *testing.T) {
+	var out bytes.Buffer
+	printHelpEntry(&out, "dcm list", []string{"List all the available services."})
+	printHelpEntry(&out, "dcm a-very-long-command <args>", []string{"First line.", "Second line."})
+	assert.Equal(t, ""+
+		"  dcm list                List all the available services.\n"+
+		"  dcm a-very-long-command <args>\n"+
+		"                          First line.\n"+
+		"                          Second line.\n", out.String())
+}
+
+func TestVersionCommand(t *testing.T) {
+	version := Version
+	defer func() { Version = version }()
+	Version = "1.2.0"
+
+	var out bytes.Buffer
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = &out
+	code, err := getCommand("version").Run(dcm, []string{})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "DCM version 1.2.0\n", out.String())
+}
+
+func TestRunCommandSelector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mock := &CmdMock{}
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = ioutil.Discard
+	dcm.Cmd = mock
+	dcm.Config.Dir = dir
+	dcm.Config.Settings.WaitTimeout = 0
+	dcm.Config.Config = yamlConfig{"web": yamlConfig{}, "db": yamlConfig{}}
+	run := getCommand("run").Run
+
+	// A service name alone is a selector, not a run command
+	code, err := run(dcm, []string{"web"})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, selector{Services: []string{"web"}}, dcm.Selector)
+	assert.Equal(t, []string{"--project-directory", dir, "up", "-d", "--force-recreate", "--no-deps", "web"}, mock.args)
+
+	// Stopping or restarting a service leaves the ones it depends on alone
+	dcm.Config.Config["web"] = yamlConfig{"depends_on": []interface{}{"db"}}
+	code, err = run(dcm, []string{"stop", "web"})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--project-directory", dir, "stop", "web"}, mock.args)
+	code, err = run(dcm, []string{"restart", "web"})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--project-directory", dir, "restart", "web"}, mock.args)
+
+	code, err = run(dcm, []string{"missing"})
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown service [missing]")
+
+	code, err = dcm.Run("web")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown run command [web]")
+}
+
+func TestPurgeCommandSelector(t *testing.T) {
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
+
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = ioutil.Discard
+	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
+	dcm.Config.Project = "dcmtest"
+	dcm.Config.Config = yamlConfig{
+		"ok":        yamlConfig{"depends_on": []interface{}{"ok-worker"}},
+		"ok-worker": yamlConfig{},
+	}
+
+	// Only the containers of the service given are removed, not the ones
+	// of the services it depends on
+	code, err := getCommand("purge").Run(dcm, []string{"ok"})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	left := []string{}
+	for _, c := range f.containers {
+		left = append(left, c.ID)
+	}
+	assert.Contains(t, left, "dcmtest_ok-worker_1")
+	assert.NotContains(t, left, "dcmtest_ok_1")
+
+	code, err = dcm.Purge("ok")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown purge type [ok]")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// composeAuto is the compose binary setting telling DCM to look for the
+// compose implementation installed
+const composeAuto = "auto"
+
+// composeCandidates lists the compose binaries tried in order when the
+// setting is auto. The standalone binary comes first, so the containers of
+// the projects it already started are still found.
+var composeCandidates = []string{"docker-compose", "docker compose"}
+
+type composeKind int
+
+const (
+	composeStandaloneV1 composeKind = iota
+	composeStandaloneV2
+	composePluginV2
+)
+
+func (k composeKind) String() string {
+	switch k {
+	case composeStandaloneV1:
+		return "standalone v1"
+	case composeStandaloneV2:
+		return "standalone v2"
+	default:
+		return "plugin v2"
+	}
+}
+
+// Compose is the compose implementation DCM runs, which tells how the
+// containers and the images of the services are named.
+type Compose struct {
+	Command []string
+	Version string
+	Kind    composeKind
+}
+
+// NewCompose identifies the compose implementation from its command, as in
+// "docker compose", and the version it reports.
+func NewCompose(binary, version string) *Compose {
+	c := &Compose{
+		Command: strings.Fields(binary),
+		Version: strings.TrimPrefix(strings.TrimSpace(version), "v"),
+	}
+
+	major, _ := strconv.Atoi(strings.SplitN(c.Version, ".", 2)[0])
+	switch {
+	case len(c.Command) > 1 && filepath.Base(c.Command[0]) == "docker" && c.Command[1] == "compose":
+		c.Kind = composePluginV2
+	case major >= 2:
+		c.Kind = composeStandaloneV2
+	default:
+		// Versions that can't be read are assumed to be the oldest
+		c.Kind = composeStandaloneV1
+	}
+	return c
+}
+
+func (c *Compose) String() string {
+	return fmt.Sprintf("%s %s (%s)", strings.Join(c.Command, " "), c.Version, c.Kind)
+}
+
+// ImageRepository returns the name of the image compose builds for a
+// service, e.g. project_web for v1 and project-web for v2. The project
+// name is normalized the way compose does, so MyProject builds myproject-web.
+func (c *Compose) ImageRepository(project, service string) string {
+	project = getComposeProjectName(project)
+	if c.Kind == composeStandaloneV1 {
+		return project + "_" + service
+	}
+	return project + "-" + service
+}
+
+// getCompose finds the compose implementation to run, once: the one given
+// with --compose, the compose_binary setting, or the first one installed.
+func (d *Dcm) getCompose() (*Compose, error) {
+	if d.Compose != nil {
+		return d.Compose, nil
+	}
+
+	binary := d.ComposeBinary
+	if binary == "" {
+		binary = d.Config.Settings.ComposeBinary
+	}
+	candidates := []string{binary}
+	if binary == composeAuto {
+		candidates = composeCandidates
+	}
+
+	for _, candidate := range candidates {
+		parts := strings.Fields(candidate)
+		out, err := d.Cmd.Exec(parts[0], append(parts[1:], "version", "--short")...).Out()
+		if err != nil {
+			if binary != composeAuto {
+				return nil, fmt.Errorf("Error running compose binary [%s]: %v", binary, d.Cmd.FormatError(err, out))
+			}
+			continue
+		}
+		d.Compose = NewCompose(candidate, d.Cmd.FormatOutput(out))
+		return d.Compose, nil
+	}
+	return nil, errors.New(
+		"No compose implementation found, install either docker-compose or the docker compose plugin, " +
+			"or set the compose_binary setting",
+	)
+}
+
+// compose prepares a compose command, run with the compose implementation
+// found
+func (d *Dcm) compose(args ...string) (Executable, error) {
+	c, err := d.getCompose()
+	if err != nil {
+		return nil, err
+	}
+	return d.Cmd.Exec(c.Command[0], append(c.Command[1:], args...)...), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCompose(t *testing.T) {
+	fixtures := []struct {
+		binary, version string
+		kind            composeKind
+		image           string
+	}{
+		{"docker-compose", "1.29.2\n", composeStandaloneV1, "project_web"},
+		{"/usr/local/bin/docker-compose", "v2.21.0", composeStandaloneV2, "project-web"},
+		{"docker compose", "2.24.5", composePluginV2, "project-web"},
+		{"/usr/bin/docker compose", "", composePluginV2, "project-web"},
+		{"docker-compose", "", composeStandaloneV1, "project_web"},
+	}
+
+	for n, test := range fixtures {
+		c := NewCompose(test.binary, test.version)
+		assert.Equal(t, test.kind, c.Kind, "[%d: %s] Incorrect kind returned", n, test.binary)
+		assert.Equal(t, test.image, c.ImageRepository("project", "web"), "[%d: %s] Incorrect image repository returned", n, test.binary)
+	}
+
+	// Compose lowercases the project name and drops the characters it
+	// doesn't allow in image names
+	assert.Equal(t, "myproject_web", NewCompose("docker-compose", "1.29.2").ImageRepository("My.Project", "web"))
+	assert.Equal(t, "myproject-web", NewCompose("docker compose", "2.24.5").ImageRepository("MyProject", "web"))
+
+	assert.Equal(t, "docker compose 2.24.5 (plugin v2)", NewCompose("docker compose", "v2.24.5").String())
+}
+
+func TestGetCompose(t *testing.T) {
+	fixtures := []struct {
+		name, binary, dir string
+		compose           *Compose
+		err               error
+	}{
+		{
+			name:    "Standalone binary found first",
+			binary:  composeAuto,
+			dir:     "/test/compose_v1",
+			compose: &Compose{[]string{"docker-compose"}, "1.29.2", composeStandaloneV1},
+		},
+		{
+			name:    "Standalone v2 binary",
+			binary:  composeAuto,
+			dir:     "/test/compose_v2",
+			compose: &Compose{[]string{"docker-compose"}, "2.21.0", composeStandaloneV2},
+		},
+		{
+			name:    "Plugin found when there is no standalone binary",
+			binary:  composeAuto,
+			dir:     "/test/compose_plugin",
+			compose: &Compose{[]string{"docker", "compose"}, "2.24.5", composePluginV2},
+		},
+		{
+			name:    "Binary given",
+			binary:  "docker compose",
+			dir:     "/test/compose_v1",
+			compose: &Compose{[]string{"docker", "compose"}, "2.24.5", composePluginV2},
+		},
+		{
+			name:   "Negative case: binary given not found",
+			binary: "docker-compose",
+			dir:    "/test/compose_plugin",
+			err:    errors.New("Error running compose binary [docker-compose]: exit status 127: docker-compose: command not found"),
+		},
+		{
+			name:   "Negative case: no compose installed",
+			binary: composeAuto,
+			dir:    "/test/no_compose",
+			err: errors.New("No compose implementation found, install either docker-compose or the docker compose plugin, " +
+				"or set the compose_binary setting"),
+		},
+	}
+
+	for n, test := range fixtures {
+		dcm := NewDcm(NewConfig(), []string{})
+		dcm.Cmd = &CmdMock{}
+		dcm.Cmd.Setdir(test.dir)
+		dcm.Config.Settings.ComposeBinary = test.binary
+
+		compose, err := dcm.getCompose()
+		if test.err != nil {
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.compose, compose, "[%d: %s] Incorrect compose returned", n, test.name)
+	}
+
+	// The option takes precedence over the setting
+	dcm := NewDcm(NewConfig(), []string{"--compose", "docker compose", "list"})
+	dcm.Cmd = &CmdMock{}
+	dcm.Cmd.Setdir("/test/compose_v1")
+	_, _, err := dcm.parseOptions(dcm.Args)
+	require.Nil(t, err)
+	compose, err := dcm.getCompose()
+	assert.NoError(t, err)
+	assert.Equal(t, composePluginV2, compose.Kind)
+
+	// And the implementation found is kept
+	dcm.ComposeBinary = "docker-compose"
+	compose, err = dcm.getCompose()
+	assert.NoError(t, err)
+	assert.Equal(t, composePluginV2, compose.Kind)
+}
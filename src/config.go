@@ -1,75 +1,574 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 type yamlConfig map[interface{}]interface{}
 
+// MarshalJSON encodes the config as a JSON object, which only has string keys
+func (c yamlConfig) MarshalJSON() ([]byte, error) {
+	object := make(map[string]interface{}, len(c))
+	for key, value := range c {
+		object[fmt.Sprint(key)] = value
+	}
+	return json.Marshal(object)
+}
+
 type Config struct {
 	Dir, File, Project, Srv string
 	Config                  yamlConfig
+
+	// Config files merged together, starting with the main config file
+	// followed by the override files found next to it
+	Files []string
+
+	// Service whose repo the working directory is in, if any
+	CurrentService string
+
+	// Where the dir, project, file and srv locations come from: a command
+	// line option, an environment variable, the settings or a default
+	Origins map[string]string
+
+	// Version of the compose file format, empty for version 1 files and
+	// versionless Compose Specification files
+	Version string
+
+	// Whether the services are defined in a services section, as in all
+	// the file formats but version 1
+	HasSections bool
+
+	// Top level sections shared by the services
+	Volumes, Networks, Secrets yamlConfig
+
+	// Other top level keys, like configs, name or include, that DCM passes
+	// on to compose as is
+	Others yamlConfig
+
+	// Project wide defaults from the x-dcm block
+	Settings Settings
+}
+
+// Options are the global options given on the command line. The locations
+// take precedence over the environment variables and the settings.
+type Options struct {
+	Dir, Project, Srv string
+
+	// Config files to merge, instead of the main config file and its
+	// override files
+	Files []string
+
+	// Number of services processed in parallel, 0 when not given
+	Jobs int
 }
 
+const originDefault = "default"
+
 func NewConfigFile() (*Config, error) {
 	c := NewConfig()
-	content, err := ioutil.ReadFile(c.File)
-	if err != nil {
+	if err := c.loadFile(); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+func (c *Config) loadFile() error {
+	wd, _ := os.Getwd()
+	if err := c.discover(wd); err != nil {
+		return err
+	}
+	// The folder may have changed since the environment was read
+	if err := c.loadState(); err != nil {
+		return err
+	}
+	c.setLocations()
+
+	doc, err := c.loadFiles()
+	if err != nil {
+		return err
+	}
+	if err := c.loadCompose(doc); err != nil {
+		return err
+	}
 
-	if err := yaml.Unmarshal(content, &c.Config); err != nil {
-		return nil, fmt.Errorf("Error parsing config file: %s", err)
+	c.CurrentService = c.getCurrentService(wd)
+	return nil
+}
+
+// loadFiles reads the config files in the order they are merged, and
+// returns the merged document.
+func (c *Config) loadFiles() (yamlConfig, error) {
+	if c.Origins["file"] != "--config" {
+		c.Files = getConfigFiles(c.File)
 	}
 
-	if isDockerComposeVersion2(c.Config) {
-		services, ok := getMapVal(c.Config, "services").(yamlConfig)
-		if ok {
-			c.Config = services
+	var merged yamlConfig
+	for _, file := range c.Files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc yamlConfig
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("Error parsing config file [%s]: %s", file, err)
+		}
+		if err := normalizeDoc(doc); err != nil {
+			return nil, err
+		}
+
+		if merged == nil {
+			merged = doc
+			continue
+		}
+		if !isOnlyExtensions(doc) && hasServicesSection(doc) != hasServicesSection(merged) {
+			return nil, fmt.Errorf(
+				"Error reading config file [%s]: services must be defined the same way as in [%s]",
+				file, c.File,
+			)
 		}
+		merged = mergeConfigs(merged, doc, "").(yamlConfig)
 	}
 
-	return c, nil
+	return merged, nil
+}
+
+// getConfigFiles lists the config file followed by the override files that
+// exist next to it: <project>.override.yml, meant to be shared, and then
+// <project>.local.yml, meant to be kept out of version control.
+func getConfigFiles(file string) []string {
+	files := []string{file}
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, suffix := range []string{".override.yml", ".local.yml"} {
+		if _, err := os.Stat(base + suffix); err == nil {
+			files = append(files, base+suffix)
+		}
+	}
+	return files
+}
+
+func (c *Config) loadCompose(doc yamlConfig) error {
+	c.Config = yamlConfig{}
+
+	env, err := c.getEnv()
+	if err != nil {
+		return err
+	}
+
+	// The settings are loaded first, as they tell where the srv directory
+	// used by the ${DCM_SRV} variable is
+	settings, err := interpolateConfig(doc["x-dcm"], env, "x-dcm")
+	if err != nil {
+		return err
+	}
+	if err := c.loadSettings(settings); err != nil {
+		return err
+	}
+
+	env["DCM_SRV"] = c.Srv
+	interpolated, err := interpolateConfig(doc, env, "")
+	if err != nil {
+		return err
+	}
+	doc = interpolated.(yamlConfig)
+
+	if err := c.loadSections(doc); err != nil {
+		return err
+	}
+	if err := normalizeServices(c.Config); err != nil {
+		return err
+	}
+	if err := setImplicitBuilds(c.Config, c.Srv); err != nil {
+		return err
+	}
+	return offsetPorts(c.Config, c.Settings.PortOffset)
+}
+
+// loadSections reads the services, and the other top level sections of the
+// file formats that have them.
+func (c *Config) loadSections(doc yamlConfig) error {
+	if !hasServicesSection(doc) {
+		// Version 1 files define the services at the top level
+		for key, value := range doc {
+			if isExtensionKey(key) {
+				continue
+			}
+			c.Config[key] = value
+		}
+		return nil
+	}
+
+	c.HasSections = true
+	if version, ok := doc["version"]; ok && version != nil {
+		// Versions like 3 or 3.8 are read as numbers when not quoted
+		c.Version = fmt.Sprint(version)
+	}
+
+	for key, section := range map[string]*yamlConfig{
+		"services": &c.Config,
+		"volumes":  &c.Volumes,
+		"networks": &c.Networks,
+		"secrets":  &c.Secrets,
+	} {
+		if doc[key] == nil {
+			continue
+		}
+		value, ok := doc[key].(yamlConfig)
+		if !ok {
+			return fmt.Errorf("Error reading config file: %s must be a mapping", key)
+		}
+		*section = value
+	}
+
+	for key, value := range doc {
+		switch key {
+		case "services", "version", "volumes", "networks", "secrets":
+			continue
+		}
+		if isExtensionKey(key) {
+			continue
+		}
+		if c.Others == nil {
+			c.Others = yamlConfig{}
+		}
+		c.Others[key] = value
+	}
+
+	return nil
 }
 
 func NewConfig() *Config {
 	wd, _ := os.Getwd()
-	c := &Config{Dir: wd, Project: "dcm"}
+	c := &Config{Dir: wd, Project: defaultProject, Settings: NewSettings()}
+	c.setOrigin("dir", originWorkingDir)
+	c.setOrigin("project", originDefault)
 	return c.loadEnvConfig()
 }
 
 func (c *Config) loadEnvConfig() *Config {
 	if env := os.Getenv("DCM_DIR"); env != "" {
 		c.Dir = env
+		c.setOrigin("dir", "DCM_DIR")
 	}
 	if env := os.Getenv("DCM_PROJECT"); env != "" {
 		c.Project = env
+		c.setOrigin("project", "DCM_PROJECT")
+	}
+	if env := os.Getenv("DCM_SRV"); env != "" {
+		c.Srv = env
+		c.setOrigin("srv", "DCM_SRV")
 	}
-
-	c.File = c.Dir + "/" + c.Project + ".yml"
-	c.Srv = c.Dir + "/srv/" + c.Project
 
 	// This is created for unit test
 	if env := os.Getenv("DCM_CONFIG_FILE"); env != "" {
 		c.File = env
+		c.setOrigin("file", "DCM_CONFIG_FILE")
 	}
 
+	// A state file that can't be read is reported when the config file
+	// is loaded
+	c.loadState()
+
+	return c.setLocations()
+}
+
+// loadOptions applies the locations given on the command line. Relative
+// paths are relative to the working directory.
+func (c *Config) loadOptions(o Options) *Config {
+	if o.Dir != "" {
+		c.Dir, _ = filepath.Abs(o.Dir)
+		c.setOrigin("dir", "--dir")
+	}
+	if o.Project != "" {
+		c.Project = o.Project
+		c.setOrigin("project", "--project")
+	}
+	if o.Srv != "" {
+		c.Srv, _ = filepath.Abs(o.Srv)
+		c.setOrigin("srv", "--srv")
+	}
+	if len(o.Files) > 0 {
+		c.Files = []string{}
+		for _, file := range o.Files {
+			file, _ = filepath.Abs(file)
+			c.Files = append(c.Files, file)
+		}
+		c.File = c.Files[0]
+		c.setOrigin("file", "--config")
+	}
+
+	return c.setLocations()
+}
+
+// setLocations derives the locations that are not given from the project
+// directory and name.
+func (c *Config) setLocations() *Config {
+	if c.isDefault("file") {
+		c.File = c.Dir + "/" + c.Project + ".yml"
+		c.setOrigin("file", originDefault)
+	}
+	if c.isDefault("srv") {
+		c.Srv = c.Dir + "/srv/" + c.Project
+		c.setOrigin("srv", originDefault)
+	}
+	if c.Origins["file"] != "--config" {
+		c.Files = []string{c.File}
+	}
 	return c
 }
 
-func isDockerComposeVersion2(config yamlConfig) bool {
-	var ok bool
-	_, ok = getMapVal(config, "version").(string)
-	if !ok {
+func (c *Config) setOrigin(name, origin string) {
+	if c.Origins == nil {
+		c.Origins = map[string]string{}
+	}
+	c.Origins[name] = origin
+}
+
+func (c *Config) isDefault(name string) bool {
+	origin := c.Origins[name]
+	return origin == "" || origin == originDefault
+}
+
+// hasServicesSection tells whether the services are defined under the
+// services section, as in compose file versions 2 and 3 and in versionless
+// Compose Specification files, rather than at the top level as in version 1.
+func hasServicesSection(doc yamlConfig) bool {
+	// A version (anything but a mapping) can only be a file format version
+	if version, ok := doc["version"]; ok {
+		if _, ok := version.(yamlConfig); !ok {
+			return true
+		}
+	}
+
+	if _, ok := doc["services"]; !ok {
 		return false
 	}
-	_, ok = getMapVal(config, "services").(yamlConfig)
+
+	// Sections that only exist in the newer formats
+	for key := range doc {
+		switch key {
+		case "volumes", "networks", "secrets", "configs", "name":
+			return true
+		}
+		if isExtensionKey(key) {
+			return true
+		}
+	}
+
+	// Without any other hint, tell a version 1 service named "services"
+	// apart from a services section: a services section only contains
+	// service definitions, which are mappings
+	services, ok := doc["services"].(yamlConfig)
 	if !ok {
+		return doc["services"] == nil
+	}
+	if _, ok := services["image"]; ok {
 		return false
 	}
+	for _, configs := range services {
+		if _, ok := configs.(yamlConfig); !ok && configs != nil {
+			return false
+		}
+	}
 	return true
 }
+
+func isExtensionKey(key interface{}) bool {
+	name, ok := key.(string)
+	return ok && strings.HasPrefix(name, "x-")
+}
+
+// isOnlyExtensions tells whether the document only has extension fields,
+// like an override file that only changes the x-dcm settings, which can be
+// merged into a file of any format.
+func isOnlyExtensions(doc yamlConfig) bool {
+	for key := range doc {
+		if !isExtensionKey(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeConfigs merges an override config into a base config the same way
+// compose merges multiple files: mappings are merged recursively, the
+// options taking several values get the override values appended, volumes
+// and devices are merged by their path in the container, and anything else
+// is replaced. An empty override value keeps the base value.
+func mergeConfigs(base, override interface{}, key string) interface{} {
+	if override == nil {
+		return base
+	}
+
+	switch override := override.(type) {
+	case yamlConfig:
+		base, ok := base.(yamlConfig)
+		if !ok {
+			return override
+		}
+		merged := yamlConfig{}
+		for k, v := range base {
+			merged[k] = v
+		}
+		for k, v := range override {
+			merged[k] = mergeConfigs(base[k], v, fmt.Sprint(k))
+		}
+		return merged
+	case []interface{}:
+		base, ok := base.([]interface{})
+		if !ok {
+			return override
+		}
+		switch key {
+		case "ports", "expose", "external_links", "dns", "dns_search", "tmpfs":
+			return append(append([]interface{}{}, base...), override...)
+		case "volumes", "devices":
+			return mergeMounts(base, override)
+		}
+	}
+
+	return override
+}
+
+func mergeMounts(base, override []interface{}) []interface{} {
+	merged := append([]interface{}{}, base...)
+	index := map[interface{}]int{}
+	for i, mount := range merged {
+		index[getMountTarget(mount)] = i
+	}
+	for _, mount := range override {
+		if i, ok := index[getMountTarget(mount)]; ok {
+			merged[i] = mount
+			continue
+		}
+		index[getMountTarget(mount)] = len(merged)
+		merged = append(merged, mount)
+	}
+	return merged
+}
+
+// getMountTarget returns the path in the container of a volume or device,
+// written either as "source:target[:mode]" or as a mapping.
+func getMountTarget(mount interface{}) interface{} {
+	switch mount := mount.(type) {
+	case string:
+		parts := strings.Split(mount, ":")
+		if len(parts) == 1 {
+			// Anonymous volume, only the target is given
+			return parts[0]
+		}
+		return parts[1]
+	case yamlConfig:
+		return fmt.Sprint(mount["target"])
+	}
+	return fmt.Sprint(mount)
+}
+
+// normalizeDoc normalizes the services of a config file, wherever they are
+// defined.
+func normalizeDoc(doc yamlConfig) error {
+	if hasServicesSection(doc) {
+		services, _ := doc["services"].(yamlConfig)
+		return normalizeServices(services)
+	}
+	return normalizeServices(doc)
+}
+
+// normalizeServices converts the options that compose accepts either as a
+// mapping or as a list of "key=value" strings to their mapping form, so
+// they can be read the same way regardless of the style used.
+func normalizeServices(config yamlConfig) error {
+	for service, configs := range config {
+		configs, ok := configs.(yamlConfig)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"labels", "environment"} {
+			list, ok := configs[key].([]interface{})
+			if !ok {
+				continue
+			}
+			mapping, err := getListMapping(list, key == "environment")
+			if err != nil {
+				return fmt.Errorf(
+					"Error reading %s for service [%v]: %v",
+					key, service, err,
+				)
+			}
+			configs[key] = mapping
+		}
+	}
+	return nil
+}
+
+// setImplicitBuilds builds the services with a repository from their checkout
+// in the srv folder, unless they say how to get their image. A service with
+// an image is only built when dcm.dockerfile or dcm.build_target is set.
+func setImplicitBuilds(config yamlConfig, srv string) error {
+	for service, configs := range config {
+		configs, ok := configs.(yamlConfig)
+		if !ok || configs["build"] != nil {
+			continue
+		}
+		labels, _ := configs["labels"].(yamlConfig)
+
+		values := map[string]string{}
+		for _, name := range []string{"dcm.repository", "dcm.dockerfile", "dcm.build_target"} {
+			value, err := getLabelValue(labels, name)
+			if err != nil {
+				return fmt.Errorf("Error reading configs for service [%v]: %v", service, err)
+			}
+			values[name], _ = value.(string)
+		}
+		if values["dcm.repository"] == "" {
+			continue
+		}
+		if configs["image"] != nil && values["dcm.dockerfile"] == "" && values["dcm.build_target"] == "" {
+			continue
+		}
+
+		context := filepath.Join(srv, fmt.Sprint(service))
+		if values["dcm.dockerfile"] == "" && values["dcm.build_target"] == "" {
+			configs["build"] = context
+			continue
+		}
+		build := yamlConfig{"context": context}
+		if values["dcm.dockerfile"] != "" {
+			build["dockerfile"] = values["dcm.dockerfile"]
+		}
+		if values["dcm.build_target"] != "" {
+			build["target"] = values["dcm.build_target"]
+		}
+		configs["build"] = build
+	}
+	return nil
+}
+
+func getListMapping(list []interface{}, nilWithoutValue bool) (yamlConfig, error) {
+	mapping := yamlConfig{}
+	for _, entry := range list {
+		item, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid entry [%v], must be a \"key=value\" string", entry)
+		}
+		parts := strings.SplitN(item, "=", 2)
+		switch {
+		case len(parts) == 2:
+			mapping[parts[0]] = parts[1]
+		case nilWithoutValue:
+			// An environment variable without a value is taken from
+			// the shell running compose
+			mapping[parts[0]] = nil
+		default:
+			mapping[parts[0]] = ""
+		}
+	}
+	return mapping, nil
+}
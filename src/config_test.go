@@ -48,10 +48,18 @@ func TestCreateNewConfig(t *testing.T) {
 	wd, _ := os.Getwd()
 
 	assert.Equal(t, &Config{
-		Dir:     wd,
-		Project: "dcm",
-		File:    wd + "/dcm.yml",
-		Srv:     wd + "/srv/dcm",
+		Dir:      wd,
+		Project:  "dcm",
+		File:     wd + "/dcm.yml",
+		Files:    []string{wd + "/dcm.yml"},
+		Srv:      wd + "/srv/dcm",
+		Settings: NewSettings(),
+		Origins: map[string]string{
+			"dir":     "working directory",
+			"project": "default",
+			"file":    "default",
+			"srv":     "default",
+		},
 	}, c)
 }
 
@@ -62,10 +70,18 @@ func TestCreateNewConfigWithEnvVars(t *testing.T) {
 	c := NewConfig()
 
 	assert.Equal(t, &Config{
-		Dir:     "/test/dcm/dir",
-		Project: "testproj",
-		File:    "/test/dcm/dir/testproj.yml",
-		Srv:     "/test/dcm/dir/srv/testproj",
+		Dir:      "/test/dcm/dir",
+		Project:  "testproj",
+		File:     "/test/dcm/dir/testproj.yml",
+		Files:    []string{"/test/dcm/dir/testproj.yml"},
+		Srv:      "/test/dcm/dir/srv/testproj",
+		Settings: NewSettings(),
+		Origins: map[string]string{
+			"dir":     "DCM_DIR",
+			"project": "DCM_PROJECT",
+			"file":    "default",
+			"srv":     "default",
+		},
 	}, c)
 }
 
@@ -133,26 +149,481 @@ func TestCreateNewConfigFile(t *testing.T) {
 	assert.Equal(t, expectedYaml, config.Config)
 }
 
-func TestIsDockerComposeVersion2(t *testing.T) {
-	assert.False(t, isDockerComposeVersion2(yamlConfig{
-		"foo": "bar",
-	}))
+func TestHasServicesSection(t *testing.T) {
+	fixtures := []struct {
+		name   string
+		doc    yamlConfig
+		result bool
+	}{
+		{
+			name:   "Version 1 file",
+			doc:    yamlConfig{"foo": yamlConfig{"image": "bar"}},
+			result: false,
+		},
+		{
+			name:   "Version 2 file without services",
+			doc:    yamlConfig{"version": "2", "foo": "bar"},
+			result: true,
+		},
+		{
+			name: "Version 2 file",
+			doc: yamlConfig{
+				"version":  "2",
+				"services": yamlConfig{"foo": "bar"},
+			},
+			result: true,
+		},
+		{
+			name: "Version 3 file with a numeric version",
+			doc: yamlConfig{
+				"version":  3.8,
+				"services": yamlConfig{"foo": yamlConfig{}},
+			},
+			result: true,
+		},
+		{
+			name: "Versionless file",
+			doc: yamlConfig{
+				"services": yamlConfig{"foo": yamlConfig{"image": "bar"}},
+			},
+			result: true,
+		},
+		{
+			name:   "Versionless file without any service",
+			doc:    yamlConfig{"services": nil},
+			result: true,
+		},
+		{
+			name: "Versionless file with top level sections",
+			doc: yamlConfig{
+				"services": yamlConfig{"foo": "bar"},
+				"volumes":  yamlConfig{"data": nil},
+			},
+			result: true,
+		},
+		{
+			name: "Versionless file with extension fields",
+			doc: yamlConfig{
+				"services": yamlConfig{"foo": "bar"},
+				"x-common": yamlConfig{"restart": "always"},
+			},
+			result: true,
+		},
+		{
+			name: "Version 1 file with a service named services",
+			doc: yamlConfig{
+				"services": yamlConfig{"image": "foo"},
+			},
+			result: false,
+		},
+		{
+			name: "Version 1 file with a service named services using local build",
+			doc: yamlConfig{
+				"services": yamlConfig{"build": "./foo", "labels": yamlConfig{}},
+			},
+			result: false,
+		},
+		{
+			name: "Version 1 file with a service named version",
+			doc: yamlConfig{
+				"version":  yamlConfig{"image": "foo"},
+				"services": yamlConfig{"build": "./foo"},
+			},
+			result: false,
+		},
+	}
+
+	for n, test := range fixtures {
+		result := hasServicesSection(test.doc)
+		assert.Equal(t, test.result, result, "[%d: %s] Incorrect result returned", n, test.name)
+	}
+}
+
+var yamlFixtureVersion3 string = `
+version: 3.8
+services:
+  web:
+    image: nginx
+    volumes:
+      - data:/data
+volumes:
+  data: {}
+networks:
+  front:
+    driver: bridge
+secrets:
+  token:
+    file: ./token.txt
+`
 
-	assert.False(t, isDockerComposeVersion2(yamlConfig{
-		"version": "2",
-		"foo":     "bar",
-	}))
+var yamlFixtureVersionless string = `
+x-common: &common
+  restart: always
+services:
+  web:
+    <<: *common
+    image: nginx
+volumes:
+  data:
+configs:
+  site:
+    file: ./site.conf
+name: shop
+`
 
-	assert.False(t, isDockerComposeVersion2(yamlConfig{
-		"services": yamlConfig{
-			"foo": "bar",
+func TestLoadCompose(t *testing.T) {
+	var (
+		file   string
+		err    error
+		config *Config
+	)
+
+	file = helperCreateTestFile(t, "good_yaml_ver3", yamlFixtureVersion3)
+	defer os.Remove(file)
+	os.Setenv("DCM_CONFIG_FILE", file)
+	config, err = NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, "3.8", config.Version)
+	assert.Equal(t, yamlConfig{
+		"web": yamlConfig{
+			"image":   "nginx",
+			"volumes": []interface{}{"data:/data"},
 		},
-	}))
+	}, config.Config)
+	assert.Equal(t, yamlConfig{"data": yamlConfig{}}, config.Volumes)
+	assert.Equal(t, yamlConfig{"front": yamlConfig{"driver": "bridge"}}, config.Networks)
+	assert.Equal(t, yamlConfig{"token": yamlConfig{"file": "./token.txt"}}, config.Secrets)
+
+	file = helperCreateTestFile(t, "good_yaml_versionless", yamlFixtureVersionless)
+	defer os.Remove(file)
+	os.Setenv("DCM_CONFIG_FILE", file)
+	config, err = NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, "", config.Version)
+	assert.Equal(t, yamlConfig{
+		"web": yamlConfig{
+			"image":   "nginx",
+			"restart": "always",
+		},
+	}, config.Config)
+	assert.Equal(t, yamlConfig{"data": nil}, config.Volumes)
+	assert.Nil(t, config.Networks)
+	// The other top level keys are kept for compose, but the extensions
+	assert.Equal(t, yamlConfig{
+		"configs": yamlConfig{"site": yamlConfig{"file": "./site.conf"}},
+		"name":    "shop",
+	}, config.Others)
+
+	// Extension fields are never services, even in version 1 files
+	config = NewConfig()
+	err = config.loadCompose(yamlConfig{
+		"web":      yamlConfig{"image": "nginx"},
+		"x-common": yamlConfig{"restart": "always"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, yamlConfig{"web": yamlConfig{"image": "nginx"}}, config.Config)
 
-	assert.True(t, isDockerComposeVersion2(yamlConfig{
-		"version": "2",
-		"services": yamlConfig{
-			"foo": "bar",
+	// Negative case: services is not a mapping
+	err = config.loadCompose(yamlConfig{
+		"version":  "3",
+		"services": []interface{}{"web"},
+	})
+	assert.EqualError(t, err, "Error reading config file: services must be a mapping")
+}
+
+var yamlFixtureListLabels string = `
+version: "2"
+services:
+  api:
+    labels:
+      - "dcm.repository=git@github.com:username/api.git"
+      - dcm.branch=feature=x
+      - com.example.flag
+    environment:
+      - DEBUG=1
+      - HOME
+  db:
+    image: postgres
+    labels:
+      dcm.updateable: "false"
+    environment:
+      POSTGRES_DB: api
+`
+
+func TestCreateNewConfigFileWithListLabels(t *testing.T) {
+	file := helperCreateTestFile(t, "list_labels_yaml", yamlFixtureListLabels)
+	defer os.Remove(file)
+	os.Setenv("DCM_CONFIG_FILE", file)
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+
+	assert.Equal(t, yamlConfig{
+		"api": yamlConfig{
+			// Built from the checkout, without build nor image
+			"build": config.Srv + "/api",
+			"labels": yamlConfig{
+				"dcm.repository":   "git@github.com:username/api.git",
+				"dcm.branch":       "feature=x",
+				"com.example.flag": "",
+			},
+			"environment": yamlConfig{
+				"DEBUG": "1",
+				"HOME":  nil,
+			},
+		},
+		"db": yamlConfig{
+			"image": "postgres",
+			"labels": yamlConfig{
+				"dcm.updateable": "false",
+			},
+			"environment": yamlConfig{
+				"POSTGRES_DB": "api",
+			},
 		},
-	}))
+	}, config.Config)
+
+	s, err := config.Service("api")
+	require.Nil(t, err)
+	assert.Equal(t, "git@github.com:username/api.git", s.Repository)
+	assert.Equal(t, "feature=x", s.Branch)
+}
+
+func TestNormalizeServices(t *testing.T) {
+	err := normalizeServices(yamlConfig{
+		"api": yamlConfig{
+			"labels": []interface{}{"dcm.branch=develop", 1},
+		},
+	})
+	assert.EqualError(t, err, `Error reading labels for service [api]: Invalid entry [1], must be a "key=value" string`)
+
+	config := yamlConfig{
+		"api": "invalid",
+		"web": yamlConfig{"labels": []interface{}{}},
+	}
+	assert.NoError(t, normalizeServices(config))
+	assert.Equal(t, yamlConfig{
+		"api": "invalid",
+		"web": yamlConfig{"labels": yamlConfig{}},
+	}, config)
+}
+
+func TestSetImplicitBuilds(t *testing.T) {
+	repo := "git@github.com:dcm/api.git"
+	fixtures := []struct {
+		name     string
+		configs  yamlConfig
+		expected interface{}
+	}{
+		{
+			name:     "Repository without build nor image",
+			configs:  yamlConfig{"labels": yamlConfig{"dcm.repository": repo}},
+			expected: "/srv/project/api",
+		},
+		{
+			name: "Dockerfile and target",
+			configs: yamlConfig{"labels": yamlConfig{
+				"dcm.repository":   repo,
+				"dcm.dockerfile":   "docker/Dockerfile.dev",
+				"dcm.build_target": "dev",
+			}},
+			expected: yamlConfig{
+				"context":    "/srv/project/api",
+				"dockerfile": "docker/Dockerfile.dev",
+				"target":     "dev",
+			},
+		},
+		{
+			name: "Image built from the checkout with a target",
+			configs: yamlConfig{
+				"image":  "dcm/api",
+				"labels": yamlConfig{"dcm.repository": repo, "dcm.build_target": "dev"},
+			},
+			expected: yamlConfig{"context": "/srv/project/api", "target": "dev"},
+		},
+		{
+			name: "Image pulled",
+			configs: yamlConfig{
+				"image":  "dcm/api",
+				"labels": yamlConfig{"dcm.repository": repo},
+			},
+			expected: nil,
+		},
+		{
+			name: "Build given",
+			configs: yamlConfig{
+				"build":  "./api",
+				"labels": yamlConfig{"dcm.repository": repo, "dcm.dockerfile": "Dockerfile.dev"},
+			},
+			expected: "./api",
+		},
+		{
+			name:     "No repository",
+			configs:  yamlConfig{"labels": yamlConfig{"dcm.dockerfile": "Dockerfile.dev"}},
+			expected: nil,
+		},
+	}
+
+	for n, test := range fixtures {
+		config := yamlConfig{"api": test.configs}
+		assert.NoError(t, setImplicitBuilds(config, "/srv/project"), "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.expected, test.configs["build"], "[%d: %s] Incorrect build returned", n, test.name)
+	}
+
+	// Negative case: invalid label
+	err := setImplicitBuilds(yamlConfig{"api": yamlConfig{
+		"labels": yamlConfig{"dcm.repository": repo, "dcm.dockerfile": []interface{}{"Dockerfile"}},
+	}}, "/srv/project")
+	assert.EqualError(t, err, "Error reading configs for service [api]: Label [dcm.dockerfile] must be a string, got [[Dockerfile]]")
+}
+
+var yamlFixtureMainFile string = `
+version: "2"
+x-dcm:
+  default_branch: master
+services:
+  api:
+    build: ./srv/project/api
+    ports:
+      - "8080:80"
+    volumes:
+      - ./srv/project/api:/app
+      - logs:/var/log
+    environment:
+      - DEBUG=0
+    labels:
+      dcm.repository: git@github.com:username/api.git
+      dcm.branch: master
+volumes:
+  logs:
+`
+
+var yamlFixtureOverrideFile string = `
+services:
+  api:
+    ports:
+      - "9229:9229"
+    labels:
+      - dcm.branch=develop
+`
+
+var yamlFixtureLocalFile string = `
+x-dcm:
+  default_branch: develop
+services:
+  api:
+    volumes:
+      - /home/me/api:/app
+    environment:
+      DEBUG: 1
+    labels:
+      dcm.branch: feature-x
+`
+
+func TestCreateNewConfigFileWithOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := dir + "/project.yml"
+	require.Nil(t, ioutil.WriteFile(file, []byte(yamlFixtureMainFile), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/project.override.yml", []byte(yamlFixtureOverrideFile), 0644))
+	os.Setenv("DCM_CONFIG_FILE", file)
+
+	// Positive case: the override file alone is merged
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, []string{file, dir + "/project.override.yml"}, config.Files)
+	s, err := config.Service("api")
+	require.Nil(t, err)
+	assert.Equal(t, "develop", s.Branch)
+
+	// Positive case: the local file is merged last
+	require.Nil(t, ioutil.WriteFile(dir+"/project.local.yml", []byte(yamlFixtureLocalFile), 0644))
+	config, err = NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, []string{file, dir + "/project.override.yml", dir + "/project.local.yml"}, config.Files)
+	assert.Equal(t, "2", config.Version)
+	assert.Equal(t, "develop", config.Settings.DefaultBranch)
+	assert.Equal(t, yamlConfig{"logs": nil}, config.Volumes)
+	assert.Equal(t, yamlConfig{
+		"api": yamlConfig{
+			"build":       "./srv/project/api",
+			"ports":       []interface{}{"8080:80", "9229:9229"},
+			"volumes":     []interface{}{"/home/me/api:/app", "logs:/var/log"},
+			"environment": yamlConfig{"DEBUG": 1},
+			"labels": yamlConfig{
+				"dcm.repository": "git@github.com:username/api.git",
+				"dcm.branch":     "feature-x",
+			},
+		},
+	}, config.Config)
+
+	// Negative case: the services are defined in a different way
+	require.Nil(t, ioutil.WriteFile(dir+"/project.local.yml", []byte("api:\n  image: api\n"), 0644))
+	_, err = NewConfigFile()
+	assert.EqualError(t, err, "Error reading config file ["+dir+"/project.local.yml]: services must be defined the same way as in ["+file+"]")
+
+	// Negative case: bad YAML in an override file
+	require.Nil(t, ioutil.WriteFile(dir+"/project.local.yml", []byte(yamlFixtureBad), 0644))
+	_, err = NewConfigFile()
+	assert.Contains(t, err.Error(), "Error parsing config file ["+dir+"/project.local.yml]: ")
+}
+
+func TestMergeConfigs(t *testing.T) {
+	fixtures := []struct {
+		name                     string
+		base, override, expected interface{}
+		key                      string
+	}{
+		{
+			name:     "Scalars are replaced",
+			base:     "nginx",
+			override: "nginx:alpine",
+			expected: "nginx:alpine",
+		},
+		{
+			name:     "Empty values keep the base value",
+			base:     yamlConfig{"image": "nginx"},
+			override: nil,
+			expected: yamlConfig{"image": "nginx"},
+		},
+		{
+			name:     "Mappings are merged recursively",
+			base:     yamlConfig{"build": yamlConfig{"context": ".", "dockerfile": "Dockerfile"}},
+			override: yamlConfig{"build": yamlConfig{"dockerfile": "Dockerfile.dev"}, "image": "api"},
+			expected: yamlConfig{"build": yamlConfig{"context": ".", "dockerfile": "Dockerfile.dev"}, "image": "api"},
+		},
+		{
+			name:     "Lists are replaced",
+			base:     []interface{}{"npm", "start"},
+			override: []interface{}{"npm", "run", "dev"},
+			expected: []interface{}{"npm", "run", "dev"},
+			key:      "command",
+		},
+		{
+			name:     "Multiple value options are appended",
+			base:     []interface{}{"8.8.8.8"},
+			override: []interface{}{"8.8.4.4"},
+			expected: []interface{}{"8.8.8.8", "8.8.4.4"},
+			key:      "dns",
+		},
+		{
+			name:     "Volumes are merged by their path in the container",
+			base:     []interface{}{"./api:/app:ro", "/tmp", yamlConfig{"source": "logs", "target": "/var/log"}},
+			override: []interface{}{"/tmp", yamlConfig{"type": "tmpfs", "target": "/var/log"}, "/home/me/api:/app", "data:/data"},
+			expected: []interface{}{"/home/me/api:/app", "/tmp", yamlConfig{"type": "tmpfs", "target": "/var/log"}, "data:/data"},
+			key:      "volumes",
+		},
+		{
+			name:     "Values of a different type are replaced",
+			base:     yamlConfig{"dns": "8.8.8.8"},
+			override: yamlConfig{"dns": []interface{}{"8.8.4.4"}},
+			expected: yamlConfig{"dns": []interface{}{"8.8.4.4"}},
+		},
+	}
+
+	for n, test := range fixtures {
+		merged := mergeConfigs(test.base, test.override, test.key)
+		assert.Equal(t, test.expected, merged, "[%d: %s] Incorrect config returned", n, test.name)
+	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels compose sets on the containers it creates
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	composeNumberLabel  = "com.docker.compose.container-number"
+)
+
+// container is a replica of a service, as listed by docker ps
+type container struct {
+	ID, Name string
+	Index    int
+	Running  bool
+	State    string
+	// Ports are the ports published on the host, as in
+	// 0.0.0.0:8080->80/tcp
+	Ports []string
+}
+
+// getComposeProjectName returns the project name the way compose labels the
+// containers with it: lower case, without the characters it doesn't allow.
+func getComposeProjectName(project string) string {
+	return regexp.MustCompile(`[^-_a-z0-9]`).ReplaceAllString(strings.ToLower(project), "")
+}
+
+// getContainers lists the containers of a service, running or not, ordered
+// by replica index. They are found by the labels compose sets on them, so
+// the services sharing a name prefix and the containers with a
+// container_name are told apart.
+func (d *Dcm) getContainers(service string) ([]container, error) {
+	engine, err := d.getEngine()
+	if err != nil {
+		return nil, err
+	}
+	listed, err := engine.ListContainers(
+		true,
+		composeProjectLabel+"="+getComposeProjectName(d.Config.Project),
+		composeServiceLabel+"="+service,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []container{}
+	for _, c := range listed {
+		index, _ := strconv.Atoi(c.Labels[composeNumberLabel])
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		ports := []string{}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				ports = append(ports, formatEnginePort(p))
+			}
+		}
+		containers = append(containers, container{
+			ID:      c.ID,
+			Name:    name,
+			Index:   index,
+			Running: c.State == "running",
+			State:   c.State,
+			Ports:   ports,
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Index < containers[j].Index
+	})
+	return containers, nil
+}
+
+// formatEnginePort formats a published port the way docker ps does
+func formatEnginePort(p EnginePort) string {
+	ip := p.IP
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return fmt.Sprintf("%s:%d->%d/%s", ip, p.PublicPort, p.PrivatePort, p.Type)
+}
+
+// getRunningContainer returns the running replica of a service with the
+// given index, or the first running one when the index is 0.
+func (d *Dcm) getRunningContainer(service string, index int) (container, error) {
+	containers, err := d.getContainers(service)
+	if err != nil {
+		return container{}, err
+	}
+	for _, c := range containers {
+		if c.Running && (index == 0 || c.Index == index) {
+			return c, nil
+		}
+	}
+	if index != 0 {
+		return container{}, fmt.Errorf("No running container [%d] found for service [%s]", index, service)
+	}
+	return container{}, fmt.Errorf("No running container found for service [%s]", service)
+}
+
+// isRunning tells whether a replica of the service is running
+func (d *Dcm) isRunning(service string) (bool, error) {
+	containers, err := d.getContainers(service)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range containers {
+		if c.Running {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetComposeProjectName(t *testing.T) {
+	assert.Equal(t, "instance1", getComposeProjectName("instance1"))
+	assert.Equal(t, "my_project-2", getComposeProjectName("My_Project-2"))
+	assert.Equal(t, "billingapi", getComposeProjectName("billing.api"))
+}
+
+func TestGetContainers(t *testing.T) {
+	fixtures := []struct {
+		name, service string
+		containers    []container
+	}{
+		{
+			name:       "Positive case: no container",
+			service:    "missing",
+			containers: []container{},
+		},
+		{
+			name:    "Positive case: replicas ordered by index, without the ones of other projects",
+			service: "ok",
+			containers: []container{
+				{
+					ID: "dcmtest_ok_1", Name: "dcmtest_ok_1", Index: 1, Running: true, State: "running",
+					Ports: []string{"0.0.0.0:8080->80/tcp", "[::]:8080->80/tcp"},
+				},
+				{ID: "dcmtest_ok_2", Name: "dcmtest_ok_2", Index: 2, Running: false, State: "exited", Ports: []string{}},
+				{ID: "dcmtest_ok_3", Name: "dcmtest_ok_3", Index: 3, Running: true, State: "running", Ports: []string{}},
+			},
+		},
+		{
+			name:    "Positive case: service sharing a name prefix",
+			service: "ok-worker",
+			containers: []container{
+				{ID: "dcmtest_ok-worker_1", Name: "dcmtest_ok-worker_1", Index: 1, Running: true, State: "running", Ports: []string{}},
+			},
+		},
+	}
+
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Engine = engine
+	dcm.Config.Project = "DcmTest"
+
+	for n, test := range fixtures {
+		containers, err := dcm.getContainers(test.service)
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.containers, containers, "[%d: %s] Incorrect containers returned", n, test.name)
+	}
+
+	// Negative case: failed to list the containers
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	_, err := dcm.getContainers("ok")
+	assert.EqualError(t, err, "Error response from daemon: fake error for /containers/json")
+}
+
+func TestGetRunningContainer(t *testing.T) {
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Engine = engine
+	dcm.Config.Project = "dcmtest"
+
+	c, err := dcm.getRunningContainer("ok", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "dcmtest_ok_1", c.ID)
+
+	c, err = dcm.getRunningContainer("ok", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "dcmtest_ok_3", c.ID)
+
+	_, err = dcm.getRunningContainer("ok", 2)
+	assert.EqualError(t, err, "No running container [2] found for service [ok]")
+
+	_, err = dcm.getRunningContainer("missing", 0)
+	assert.EqualError(t, err, "No running container found for service [missing]")
+
+	running, err := dcm.isRunning("ok")
+	assert.NoError(t, err)
+	assert.True(t, running)
+
+	running, err = dcm.isRunning("missing")
+	assert.NoError(t, err)
+	assert.False(t, running)
+
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	_, err = dcm.isRunning("ok")
+	assert.EqualError(t, err, "Error response from daemon: fake error for /containers/json")
+}
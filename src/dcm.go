@@ -2,57 +2,141 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 )
 
-type doForService func(string, yamlConfig) (int, error)
+type doForService func(*Dcm, *Service) (int, error)
 
 type Dcm struct {
-	Config *Config
-	Args   []string
-	Cmd    Executable
+	Config    *Config
+	Args      []string
+	Cmd       Executable
+	Stdout    io.Writer
+	Stderr    io.Writer
+	Jobs      int
+	KeepGoing bool
+
+	// Selector picks the services the command acts on
+	Selector selector
+
+	// ComposeBinary given on the command line, taking precedence over the
+	// setting, and Compose the implementation found
+	ComposeBinary string
+	Compose       *Compose
+
+	// Engine is the client of the docker daemon
+	Engine Engine
 }
 
 func NewDcm(c *Config, args []string) *Dcm {
-	return &Dcm{c, args, NewCmd()}
+	return &Dcm{
+		Config: c,
+		Args:   args,
+		Cmd:    NewCmd(),
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Jobs:   c.Settings.Jobs,
+	}
 }
 
 func (d *Dcm) Command() (int, error) {
-	if len(d.Args) < 1 {
+	args, o, err := d.parseOptions(d.Args)
+	if err == flag.ErrHelp {
 		d.Usage()
-		return 1, nil
+		return 0, nil
+	}
+	if err != nil {
+		// Unknown options are handled the same way as invalid commands
+		fmt.Fprintln(d.Stderr, err)
+		d.Usage()
+		return 127, nil
+	}
+	if d.Jobs < 1 {
+		return 1, fmt.Errorf("Invalid number of jobs: %d", d.Jobs)
 	}
 
-	moreArgs := d.Args[1:]
-
-	switch d.Args[0] {
-	case "help", "h":
+	if len(args) < 1 {
 		d.Usage()
-		return 0, nil
-	case "setup":
-		return d.Setup()
-	case "run", "r":
-		return d.Run(moreArgs...)
-	case "build", "b":
-		return d.Run("build")
-	case "dir":
-		return d.Dir(moreArgs...)
-	case "shell", "sh":
-		return d.Shell(moreArgs...)
-	case "branch", "br":
-		return d.Branch(moreArgs...)
-	case "update":
-		return d.Update(moreArgs...)
-	case "purge", "rm":
-		return d.Purge(moreArgs...)
-	case "list", "ls":
-		return d.List()
-	default:
+		return 1, nil
+	}
+
+	c := getCommand(args[0])
+	if c == nil {
 		d.Usage()
 		return 127, nil
 	}
+	if len(args) > 1 && (args[1] == "--help" || args[1] == "-h") {
+		c.Usage(d.Stdout)
+		return 0, nil
+	}
+
+	d.Config.loadOptions(o)
+	if c.LoadConfig {
+		if err := d.Config.loadFile(); err != nil {
+			return 1, err
+		}
+		if o.Jobs == 0 {
+			d.Jobs = d.Config.Settings.Jobs
+		}
+	}
+
+	// Catch config problems before they show up halfway through the process
+	if c.Validate {
+		if code, err := d.validate(); err != nil {
+			return code, err
+		}
+	}
+
+	return c.Run(d, args[1:])
+}
+
+func (d *Dcm) parseOptions(args []string) ([]string, Options, error) {
+	o := Options{}
+	fs := flag.NewFlagSet("dcm", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.IntVar(&d.Jobs, "jobs", d.Jobs, "")
+	fs.IntVar(&d.Jobs, "j", d.Jobs, "")
+	fs.BoolVar(&d.KeepGoing, "keep-going", d.KeepGoing, "")
+	fs.BoolVar(&d.KeepGoing, "k", d.KeepGoing, "")
+	fs.StringVar(&o.Project, "project", "", "")
+	fs.StringVar(&o.Dir, "dir", "", "")
+	fs.StringVar(&o.Srv, "srv", "", "")
+	fs.Var((*stringList)(&o.Files), "config", "")
+	fs.StringVar(&d.ComposeBinary, "compose", d.ComposeBinary, "")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, o, err
+		}
+		return nil, o, fmt.Errorf("Error parsing options: %v", err)
+	}
+
+	// Without the option, the number of jobs comes from the settings
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "jobs" || f.Name == "j" {
+			o.Jobs = d.Jobs
+		}
+	})
+
+	return fs.Args(), o, nil
+}
+
+// stringList is a flag that can be repeated, collecting all the values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func (d *Dcm) Setup() (int, error) {
@@ -60,35 +144,31 @@ func (d *Dcm) Setup() (int, error) {
 		os.MkdirAll(d.Config.Srv, 0777)
 	}
 
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		_, ok := getMapVal(configs, "image").(string)
-		if ok {
-			// If image is defined for the service, then skip
-			// checking out the repository
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		if !s.BuildsFromCheckout() && s.Image != "" {
+			// The image is pulled, so skip checking out the repository
 			return 0, nil
 		}
-		repo, ok := getMapVal(configs, "labels", "dcm.repository").(string)
-		if !ok {
+		if s.Repository == "" {
 			return 1, fmt.Errorf(
 				"Error reading git repository config for service [%s]",
-				service,
+				s.Name,
 			)
 		}
-		dir := d.Config.Srv + "/" + service
+		dir := d.Config.Srv + "/" + s.Name
 		if _, err := os.Stat(dir); err == nil {
-			fmt.Printf("Skipping git clone for %s. Service folder already exists.\n", service)
+			fmt.Fprintf(d.Stdout, "Skipping git clone for %s. Service folder already exists.\n", s.Name)
 			return 0, nil
 		}
-		c := d.Cmd.Exec("git", "clone", repo, dir).Setdir(d.Config.Dir)
+		c := d.Cmd.Exec("git", "clone", s.Repository, dir).Setdir(d.Config.Dir)
 		if err := c.Run(); err != nil {
 			return 1, fmt.Errorf(
 				"Error cloning git repository for service [%s]: %v",
-				service, err,
+				s.Name, err,
 			)
 		}
-		branch, ok := getMapVal(configs, "labels", "dcm.branch").(string)
-		if ok {
-			c = d.Cmd.Exec("git", "checkout", branch).Setdir(dir)
+		if s.Branch != "" {
+			c = d.Cmd.Exec("git", "checkout", s.Branch).Setdir(dir)
 			if err := c.Run(); err != nil {
 				return 1, err
 			}
@@ -98,114 +178,174 @@ func (d *Dcm) Setup() (int, error) {
 }
 
 func (d *Dcm) doForEachService(fn doForService) (int, error) {
-	for service, configs := range d.Config.Config {
-		service, _ := service.(string)
-		configs, ok := configs.(yamlConfig)
-		if !ok {
-			return 1, fmt.Errorf("Error reading configs for service: %s", service)
-		}
-
-		code, err := fn(service, configs)
-		if err != nil {
-			if code == 0 {
-				fmt.Println(err)
-			} else {
-				// Only when error code is not zero and error is not nil
-				// then break the iteration and return
-				return code, err
-			}
-		}
+	// Visit the services in dependency order, so that a service is always
+	// processed after the services it depends on
+	services, err := d.Config.Services()
+	if err != nil {
+		return 1, err
+	}
+	services, err = d.selectServices(services)
+	if err != nil {
+		return 1, err
+	}
+	order, err := sortServices(services)
+	if err != nil {
+		return 1, err
 	}
 
-	return 0, nil
+	return d.runServices(services, order, fn)
 }
 
+// runCommands are the subcommands of `dcm run`, on top of execute
+var runCommands = []string{"up", "build", "start", "stop", "restart", "pre-init", "wait", "init"}
+
 func (d *Dcm) Run(args ...string) (int, error) {
 	if len(args) == 0 {
-		args = append(args, "default")
+		args = append(args, "up")
 	}
 
 	switch args[0] {
 	case "execute":
 		return d.runExecute(args[1:]...)
 	case "init":
-		fmt.Println("Initializing project:", d.Config.Project, "...")
+		fmt.Fprintln(d.Stdout, "Initializing project:", d.Config.Project, "...")
 		return d.runInit()
 	case "pre-init":
-		fmt.Println("Pre-initializating project", d.Config.Project, "...")
+		fmt.Fprintln(d.Stdout, "Pre-initializating project", d.Config.Project, "...")
 		return d.runPreInit()
+	case "wait":
+		fmt.Fprintln(d.Stdout, "Waiting for project:", d.Config.Project, "...")
+		return d.runWait()
 	case "build":
-		fmt.Println("Building project:", d.Config.Project, "...")
-		return d.Run("execute", "build")
+		fmt.Fprintln(d.Stdout, "Building project:", d.Config.Project, "...")
+		return d.runForSelected("build")
 	case "start":
-		fmt.Println("Starting project:", d.Config.Project, "...")
-		return d.Run("execute", "start")
+		fmt.Fprintln(d.Stdout, "Starting project:", d.Config.Project, "...")
+		return d.runForSelected("start")
 	case "stop":
-		fmt.Println("Stopping project:", d.Config.Project, "...")
-		return d.Run("execute", "stop")
+		fmt.Fprintln(d.Stdout, "Stopping project:", d.Config.Project, "...")
+		// The services the selected ones depend on may still be used by
+		// other services
+		d.Selector.NoDeps = true
+		return d.runForSelected("stop")
 	case "restart":
-		fmt.Println("Restarting project:", d.Config.Project, "...")
-		return d.Run("execute", "restart")
+		fmt.Fprintln(d.Stdout, "Restarting project:", d.Config.Project, "...")
+		d.Selector.NoDeps = true
+		return d.runForSelected("restart")
 	case "up":
-		fmt.Println("Bringing up project:", d.Config.Project, "...")
+		fmt.Fprintln(d.Stdout, "Bringing up project:", d.Config.Project, "...")
 		return d.runUp()
 	default:
-		return d.Run("up")
+		return 1, fmt.Errorf("Unknown run command [%s]", args[0])
 	}
 }
 
+// runForSelected runs the compose command for the selected services only,
+// or for all of them when there is no selector.
+func (d *Dcm) runForSelected(args ...string) (int, error) {
+	names, err := d.getSelectedNames()
+	if err != nil {
+		return 1, err
+	}
+	return d.Run(append(append([]string{"execute"}, args...), names...)...)
+}
+
 func (d *Dcm) runExecute(args ...string) (int, error) {
+	// Compose is handed the config as resolved by DCM rather than the
+	// config files. The project folder is still the one of the config
+	// files, where compose looks for the .env file.
+	file, err := d.Config.renderCompose()
+	if err != nil {
+		return 1, fmt.Errorf("Error rendering compose file [%s]: %v", d.Config.getRenderedFile(), err)
+	}
+	composeArgs := append([]string{"--project-directory", d.Config.Dir}, args...)
+	compose, err := d.compose(composeArgs...)
+	if err != nil {
+		return 1, err
+	}
+
 	env := append(
 		os.Environ(),
 		"COMPOSE_PROJECT_NAME="+d.Config.Project,
-		"COMPOSE_FILE="+d.Config.File,
+		"COMPOSE_FILE="+file,
 	)
-	c := d.Cmd.
-		Exec("docker-compose", args...).
-		Setdir(d.Config.Dir).
-		Setenv(env)
-	if err := c.Run(); err != nil {
+	// Let compose interpolate the DCM built-ins in the config files too
+	for name, value := range d.Config.getBuiltinEnv() {
+		env = append(env, name+"="+value)
+	}
+	if err := compose.Setdir(d.Config.Dir).Setenv(env).Run(); err != nil {
 		return 1, fmt.Errorf(
-			"Error executing `docker-compose %s`: %v",
-			strings.Join(args, " "), err,
+			"Error executing `%s %s`: %v",
+			strings.Join(d.Compose.Command, " "), strings.Join(args, " "), err,
 		)
 	}
 	return 0, nil
 }
 
 func (d *Dcm) runInit() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		shell := d.getShellExecutable(configs)
-		init, ok := getMapVal(configs, "labels", "dcm.initscript").(string)
-		if !ok {
-			fmt.Println("Skipping init script for service:", service, "...")
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		if s.InitScript == "" && s.ContainerInitScript == "" {
+			fmt.Fprintln(d.Stdout, "Skipping init script for service:", s.Name, "...")
 			return 0, nil
 		}
 
-		c := d.Cmd.Exec(shell, init).Setdir(d.Config.Srv + "/" + service)
-		if err := c.Run(); err != nil {
-			return 1, fmt.Errorf(
-				"Error executing init script [%s] for service [%s]: %v",
-				init, service, err,
-			)
+		if s.InitScript != "" && s.InitTarget == initTargetContainer {
+			if code, err := d.runContainerInit(s, s.InitScript); err != nil {
+				return code, err
+			}
+		} else if s.InitScript != "" {
+			c := d.Cmd.Exec(s.InitShell, s.InitScript).Setdir(d.Config.Srv + "/" + s.Name)
+			if err := c.Run(); err != nil {
+				return 1, fmt.Errorf(
+					"Error executing init script [%s] for service [%s]: %v",
+					s.InitScript, s.Name, err,
+				)
+			}
+		}
+
+		if s.ContainerInitScript != "" {
+			return d.runContainerInit(s, s.ContainerInitScript)
 		}
 		return 0, nil
 	})
 }
 
+// runContainerInit executes the init script in the first running container
+// of the service, and returns the exit code of the script when it fails
+func (d *Dcm) runContainerInit(s *Service, script string) (int, error) {
+	c, err := d.getRunningContainer(s.Name, 0)
+	if err != nil {
+		return 1, err
+	}
+
+	args := []string{"exec"}
+	if s.InitUser != "" {
+		args = append(args, "--user", s.InitUser)
+	}
+	if s.InitWorkdir != "" {
+		args = append(args, "--workdir", s.InitWorkdir)
+	}
+	args = append(args, c.ID, s.InitShell, script)
+	if err := d.Cmd.Exec("docker", args...).Run(); err != nil {
+		return getExitCode(err), fmt.Errorf(
+			"Error executing init script [%s] in container [%s] for service [%s]: %v",
+			script, c.Name, s.Name, err,
+		)
+	}
+	return 0, nil
+}
+
 func (d *Dcm) runPreInit() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		shell := d.getShellExecutable(configs)
-		preInit, ok := getMapVal(configs, "labels", "dcm.pre_initscript").(string)
-		if !ok {
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		if s.PreInitScript == "" {
 			return 0, nil
 		}
 
-		c := d.Cmd.Exec(shell, preInit).Setdir(d.Config.Srv + "/" + service)
+		c := d.Cmd.Exec(s.InitShell, s.PreInitScript).Setdir(d.Config.Srv + "/" + s.Name)
 		if err := c.Run(); err != nil {
 			return 1, fmt.Errorf(
 				"Error executing pre-init script [%s] for service [%s]: %v",
-				preInit, service, err,
+				s.PreInitScript, s.Name, err,
 			)
 		}
 		return 0, nil
@@ -218,100 +358,184 @@ func (d *Dcm) runUp() (int, error) {
 		return code, err
 	}
 
-	code, err = d.Run("execute", "up", "-d", "--force-recreate")
+	// Compose stops at the first port it can't bind, and leaves the
+	// services started before it running
+	if code, err := d.checkPorts(); err != nil {
+		return code, err
+	}
+
+	args := []string{"up", "-d", "--force-recreate"}
+	if !d.Selector.IsEmpty() {
+		// The dependencies are already part of the selection, unless
+		// they were left out on purpose
+		args = append(args, "--no-deps")
+	}
+	code, err = d.runForSelected(args...)
 	if err != nil {
 		return code, err
 	}
+	// Compose is done once the containers are started, which is not when
+	// the init scripts can use them
+	if code, err := d.Run("wait"); err != nil {
+		return code, err
+	}
 	return d.Run("init")
 }
 
+// dirEntry is a folder, as printed by `dcm dir`. The service is empty for
+// the folder of the project.
+type dirEntry struct {
+	Service string `yaml:"service" json:"service"`
+	Dir     string `yaml:"dir" json:"dir"`
+}
+
 func (d *Dcm) Dir(args ...string) (int, error) {
-	var dir string
-	if len(args) < 1 {
-		dir = d.Config.Dir
-	} else {
-		dir = d.Config.Srv + "/" + args[0]
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			dir = d.Config.Dir
+	output, args, err := parseOutputOption(args, outputText)
+	if err != nil {
+		return 1, err
+	}
+	if err := checkOutputFormat(output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return 1, err
+	}
+
+	entry := dirEntry{Dir: d.Config.Dir}
+	if len(args) > 0 {
+		dir := d.Config.Srv + "/" + args[0]
+		if _, err := os.Stat(dir); err == nil {
+			entry = dirEntry{Service: args[0], Dir: dir}
 		}
 	}
-	fmt.Fprint(os.Stdout, dir)
+
+	switch output {
+	case outputText:
+		// Printed as is, for the shell function of dcm.sh to cd into it
+		fmt.Fprint(d.Stdout, entry.Dir)
+	case outputTable:
+		err = writeTable(d.Stdout, []string{"SERVICE", "DIR"}, [][]string{{entry.Service, entry.Dir}})
+	default:
+		err = writeOutput(d.Stdout, output, entry)
+	}
+	if err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
 func (d *Dcm) Shell(args ...string) (int, error) {
+	var index int
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.IntVar(&index, "index", 0, "")
+
+	// The service can be given either before or after the options
+	if err := fs.Parse(args); err != nil {
+		return 1, fmt.Errorf("Error parsing options: %v", err)
+	}
+	args = fs.Args()
+	if len(args) > 0 {
+		if err := fs.Parse(args[1:]); err != nil {
+			return 1, fmt.Errorf("Error parsing options: %v", err)
+		}
+		args = append(args[:1], fs.Args()...)
+	}
+
+	if len(args) < 1 && d.Config.CurrentService != "" {
+		args = []string{d.Config.CurrentService}
+	}
 	if len(args) < 1 {
 		return 1, errors.New("Error: no service name specified.")
 	}
 
-	cid, err := d.getContainerId(args[0], "-qf")
+	c, err := d.getRunningContainer(args[0], index)
 	if err != nil {
 		return 1, err
 	}
 
-	if err := d.Cmd.Exec("docker", "exec", "-it", cid, "bash").Run(); err != nil {
+	// The docker CLI takes care of the terminal of the interactive session
+	if err := d.Cmd.Exec("docker", "exec", "-it", c.ID, "bash").Run(); err != nil {
 		return 1, err
 	}
 
 	return 0, nil
 }
 
-func (d *Dcm) getShellExecutable(configs yamlConfig) string {
-	var shell = "/bin/bash"
-	shellDefinition, ok := getMapVal(configs, "labels", "dcm.initscript_shell").(string)
-	if ok {
-		shell = shellDefinition
+func (d *Dcm) getImageRepository(service string) (string, error) {
+	compose, err := d.getCompose()
+	if err != nil {
+		return "", err
 	}
+	repo := compose.ImageRepository(d.Config.Project, service)
 
-	return shell
-}
-
-func (d *Dcm) getContainerId(service string, flag string) (string, error) {
-	var filterTemplate string
-	if flag == "" {
-		flag = "-aq"
+	engine, err := d.getEngine()
+	if err != nil {
+		return "", err
 	}
-
-	// Find docker-compose version
-	dcVersion, err := d.Cmd.Exec("docker-compose", "--version", "--short").Out()
+	images, err := engine.ListImages()
 	if err != nil {
-		return "", d.Cmd.FormatError(err, dcVersion)
+		return "", err
 	}
-
-	// V1 filter
-	filterTemplate = "name=%s_%s_"
-	if strings.HasPrefix(string(dcVersion), "2") {
-		// V2 filter
-		filterTemplate = "name=%s-%s-"
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if strings.HasPrefix(tag, repo+":") {
+				return repo, nil
+			}
+		}
 	}
-	filter := fmt.Sprintf(filterTemplate, d.Config.Project, service)
+	return "", nil
+}
 
-	out, err := d.Cmd.Exec("docker", "ps", flag, filter).Out()
-	if err != nil {
-		return "", d.Cmd.FormatError(err, out)
-	}
-	cid := d.Cmd.FormatOutput(out)
-	return cid, nil
+// branchEntry is the checkout of a service, or of DCM itself, as printed by
+// `dcm branch`. The errors met reading it are only set in the entry for the
+// structured output.
+type branchEntry struct {
+	Service    string `yaml:"service" json:"service"`
+	Source     string `yaml:"source" json:"source"`
+	Image      string `yaml:"image,omitempty" json:"image,omitempty"`
+	Repository string `yaml:"repository,omitempty" json:"repository,omitempty"`
+	Branch     string `yaml:"branch,omitempty" json:"branch,omitempty"`
+	Error      string `yaml:"error,omitempty" json:"error,omitempty"`
 }
 
-func (d *Dcm) getImageRepository(service string) (string, error) {
-	repo := d.Config.Project + "_" + service
-	out, err := d.Cmd.Exec("docker", "images").Out()
+// String formats the entry for the text output
+func (b branchEntry) String() string {
+	switch {
+	case b.Service == "dcm":
+		return "dcm: branch: " + b.Branch
+	case b.Source == sourceImage && b.Image != "":
+		return b.Service + ": Docker hub image: " + b.Image
+	case b.Repository != "":
+		return b.Service + ": Git repo: " + b.Repository + ", branch: " + b.Branch
+	}
+	return b.Service + ": " + b.Branch
+}
+
+func (d *Dcm) Branch(args ...string) (int, error) {
+	output, args, err := parseOutputOption(args, outputText)
 	if err != nil {
-		return "", d.Cmd.FormatError(err, out)
+		return 1, err
 	}
-	if strings.Contains(string(out), repo+" ") {
-		return repo, nil
+	if err := checkOutputFormat(output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return 1, err
+	}
+	if args, err = d.setSelector(args, -1); err != nil {
+		return 1, err
 	}
-	return "", nil
-}
 
-func (d *Dcm) Branch(args ...string) (int, error) {
-	if len(args) < 1 {
-		return d.branchForAll()
-	} else {
+	if len(args) < 1 && d.Selector.IsEmpty() && d.Config.CurrentService != "" {
+		// Default to the repo of the working directory
+		args = []string{d.Config.CurrentService}
+	}
+	// Only the checkouts of the selected services are shown, whether one
+	// or several are given
+	d.Selector.NoDeps = true
+	if output != outputText {
+		return d.writeBranches(output, args)
+	}
+	if len(args) == 1 && d.Selector.IsEmpty() {
 		return d.branchForOne(args[0])
 	}
+	d.Selector.Services = append(d.Selector.Services, args...)
+	return d.branchForAll()
 }
 
 func (d *Dcm) branchForAll() (int, error) {
@@ -319,95 +543,154 @@ func (d *Dcm) branchForAll() (int, error) {
 	if err != nil {
 		return code, err
 	}
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		return d.branchForOne(service)
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		return d.branchForOne(s.Name)
 	})
 }
 
 func (d *Dcm) branchForOne(service string) (int, error) {
-	var dir string
+	b, err := d.getBranch(service)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintln(d.Stdout, b)
+	return 0, nil
+}
+
+// writeBranches prints the checkouts of the given service, or of DCM and
+// all the selected services, in a structured format
+func (d *Dcm) writeBranches(output string, args []string) (int, error) {
+	names := args
+	if len(args) != 1 || !d.Selector.IsEmpty() {
+		d.Selector.Services = append(d.Selector.Services, args...)
+		services, err := d.getSelectedServices()
+		if err != nil {
+			return 1, err
+		}
+		names = []string{"dcm"}
+		for _, s := range services {
+			names = append(names, s.Name)
+		}
+	}
 
-	fmt.Print(service + ": ")
+	entries := []branchEntry{}
+	for _, name := range names {
+		b, err := d.getBranch(name)
+		if err != nil {
+			b.Error = err.Error()
+		}
+		entries = append(entries, b)
+	}
 
-	if service == "dcm" {
-		fmt.Print("branch: ")
-		dir = d.Config.Dir
-	} else {
-		configs, ok := getMapVal(d.Config.Config, service).(yamlConfig)
-		if !ok {
-			return 0, errors.New("Service not exists.")
+	var err error
+	if output == outputTable {
+		rows := [][]string{}
+		for _, b := range entries {
+			rows = append(rows, []string{b.Service, b.Source, b.Image, b.Repository, b.Branch, b.Error})
 		}
-		if image, ok := getMapVal(configs, "image").(string); ok {
-			fmt.Println("Docker hub image:", image)
-			return 0, nil
+		err = writeTable(d.Stdout, []string{"SERVICE", "SOURCE", "IMAGE", "REPOSITORY", "BRANCH", "ERROR"}, rows)
+	} else {
+		err = writeOutput(d.Stdout, output, entries)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// getBranch reads the branch checked out for the service, or for DCM
+// itself. Services pulling their image have none.
+func (d *Dcm) getBranch(service string) (branchEntry, error) {
+	b := branchEntry{Service: service, Source: sourceRepo}
+	dir := d.Config.Dir
+	if service != "dcm" {
+		s, err := d.Config.Service(service)
+		if err != nil {
+			return b, err
 		}
-		if repo, ok := getMapVal(configs, "labels", "dcm.repository").(string); ok {
-			fmt.Print("Git repo: ", repo, ", branch: ")
+		b.Source, b.Image, b.Repository = s.Source(), s.Image, s.Repository
+		if b.Source == sourceImage && s.Image != "" {
+			return b, nil
 		}
 		dir = d.Config.Srv + "/" + service
 	}
-	if err := os.Chdir(dir); err != nil {
-		return 0, err
+	if _, err := os.Stat(dir); err != nil {
+		return b, err
 	}
-	if err := d.Cmd.Exec("git", "rev-parse", "--abbrev-ref", "HEAD").Run(); err != nil {
-		return 0, err
+	out, err := d.Cmd.Exec("git", "rev-parse", "--abbrev-ref", "HEAD").Setdir(dir).Out()
+	if err != nil {
+		return b, d.Cmd.FormatError(err, out)
 	}
-
-	return 0, nil
+	b.Branch = d.Cmd.FormatOutput(out)
+	return b, nil
 }
 
 func (d *Dcm) Update(args ...string) (int, error) {
-	if len(args) < 1 {
-		return d.updateForAll()
-	} else {
+	if len(args) < 1 && d.Selector.IsEmpty() && d.Config.CurrentService != "" {
+		// Default to the repo of the working directory
+		args = []string{d.Config.CurrentService}
+	}
+	// Only the selected services are updated, whether one or several are
+	// given, as updating a checkout doesn't need the services it depends on
+	d.Selector.NoDeps = true
+	if len(args) == 1 && d.Selector.IsEmpty() {
 		return d.updateForOne(args[0])
 	}
+	d.Selector.Services = append(d.Selector.Services, args...)
+	return d.updateForAll()
 }
 
 func (d *Dcm) updateForAll() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		return d.updateForOne(service)
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		return d.updateForOne(s.Name)
 	})
 }
 
 func (d *Dcm) updateForOne(service string) (int, error) {
-	fmt.Print(service + ": ")
+	fmt.Fprint(d.Stdout, service+": ")
 
-	configs, ok := getMapVal(d.Config.Config, service).(yamlConfig)
-	if !ok {
-		return 0, errors.New("Service not exists.")
+	s, err := d.Config.Service(service)
+	if err != nil {
+		return 0, err
 	}
 
-	updateable, ok := getMapVal(configs, "labels", "dcm.updateable").(string)
-	if ok && updateable == "false" {
+	if !s.Updateable {
 		// Service is flagged as not updateable
 		return 0, errors.New("Service not updateable. Skipping the update.")
 	}
 
-	image, ok := getMapVal(configs, "image").(string)
-	if ok {
+	if !s.BuildsFromCheckout() && s.Image != "" {
 		// Service is using docker hub image
 		// Pull the latest version from docker hub
-		if err := d.Cmd.Exec("docker", "pull", image).Run(); err != nil {
+		engine, err := d.getEngine()
+		if err != nil {
+			return 0, err
+		}
+		auth, err := d.getRegistryAuth(s.Image)
+		if err != nil {
+			return 0, err
+		}
+		if err := engine.PullImage(s.Image, auth, d.Stdout); err != nil {
 			return 0, err
 		}
 		return 0, nil
 	} else {
 		// Service is using a local build
 		// Pull the latest version from git
-		if err := os.Chdir(d.Config.Srv + "/" + service); err != nil {
+		dir := d.Config.Srv + "/" + service
+		if _, err := os.Stat(dir); err != nil {
 			return 0, err
 		}
-		branch, ok := getMapVal(configs, "labels", "dcm.branch").(string)
-		if !ok {
+		branch := s.Branch
+		if branch == "" {
 			// When service > labels > dcm.branch is not defined in
-			// the yaml config file, use "master" as default branch
-			branch = "master"
+			// the yaml config file, use the project default branch
+			branch = d.Config.Settings.DefaultBranch
 		}
-		if err := d.Cmd.Exec("git", "checkout", branch).Run(); err != nil {
+		if err := d.Cmd.Exec("git", "checkout", branch).Setdir(dir).Run(); err != nil {
 			return 0, err
 		}
-		if err := d.Cmd.Exec("git", "pull").Run(); err != nil {
+		if err := d.Cmd.Exec("git", "pull").Setdir(dir).Run(); err != nil {
 			return 0, err
 		}
 	}
@@ -415,10 +698,16 @@ func (d *Dcm) updateForOne(service string) (int, error) {
 	return 0, nil
 }
 
+// purgeTypes are what `dcm purge` can remove
+var purgeTypes = []string{"img", "images", "con", "containers", "all"}
+
 func (d *Dcm) Purge(args ...string) (int, error) {
 	if len(args) == 0 {
-		args = append(args, "default")
+		args = append(args, "containers")
 	}
+	// Only the selected services are purged, never the services, and the
+	// volumes, they depend on
+	d.Selector.NoDeps = true
 
 	switch args[0] {
 	case "img", "images":
@@ -428,17 +717,21 @@ func (d *Dcm) Purge(args ...string) (int, error) {
 	case "all":
 		return d.purgeAll()
 	default:
-		return d.Purge("containers")
+		return 1, fmt.Errorf("Unknown purge type [%s]", args[0])
 	}
 }
 
 func (d *Dcm) purgeImages() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		repo, err := d.getImageRepository(service)
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		repo, err := d.getImageRepository(s.Name)
+		if err != nil || repo == "" {
+			return 0, err
+		}
+		engine, err := d.getEngine()
 		if err != nil {
 			return 0, err
 		}
-		if err = d.Cmd.Exec("docker", "rmi", repo).Run(); err != nil {
+		if err := engine.RemoveImage(repo); err != nil {
 			return 0, err
 		}
 		return 0, nil
@@ -446,29 +739,25 @@ func (d *Dcm) purgeImages() (int, error) {
 }
 
 func (d *Dcm) purgeContainers() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		// Try to get the docker container ID from running containers list
-		cid, err := d.getContainerId(service, "-qf")
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		containers, err := d.getContainers(s.Name)
 		if err != nil {
 			return 0, err
 		}
-		if cid != "" {
-			// If the container is running then kill it first
-			if err := d.Cmd.Exec("docker", "kill", cid).Run(); err != nil {
-				return 0, err
-			}
-		} else {
-			// Otherwise, try to get the docker container ID from a list that
-			// contains all containers including not running ones
-			cid, err = d.getContainerId(service, "-aqf")
-			if err != nil {
-				return 0, err
-			}
+		engine, err := d.getEngine()
+		if err != nil {
+			return 0, err
 		}
-		if cid != "" {
-			// Finally if the container exists (whether running or not running),
-			// remove it along with all the volumes linked to it
-			if err := d.Cmd.Exec("docker", "rm", "-v", cid).Run(); err != nil {
+		for _, c := range containers {
+			// Running containers are killed first, unless they stopped
+			// in the meantime
+			if c.Running {
+				if err := engine.KillContainer(c.ID); err != nil && !isEngineError(err, http.StatusConflict) {
+					return 0, err
+				}
+			}
+			// Then removed along with all the volumes linked to them
+			if err := engine.RemoveContainer(c.ID); err != nil {
 				return 0, err
 			}
 		}
@@ -484,51 +773,101 @@ func (d *Dcm) purgeAll() (int, error) {
 	return d.Purge("images")
 }
 
-func (d *Dcm) List() (int, error) {
-	return d.doForEachService(func(service string, configs yamlConfig) (int, error) {
-		fmt.Fprintln(os.Stdout, service)
-		return 0, nil
-	})
+// listEntry is a service, as printed by `dcm list`
+type listEntry struct {
+	Name       string   `yaml:"name" json:"name"`
+	Source     string   `yaml:"source" json:"source"`
+	Image      string   `yaml:"image,omitempty" json:"image,omitempty"`
+	Repository string   `yaml:"repository,omitempty" json:"repository,omitempty"`
+	Groups     []string `yaml:"groups" json:"groups"`
+	DependsOn  []string `yaml:"depends_on" json:"depends_on"`
 }
 
-func (d *Dcm) Usage() {
-	fmt.Println("")
-	fmt.Println("DCM (Docker-Compose Manager)")
-	fmt.Println("")
-	fmt.Println("Usage:")
-	fmt.Println("  dcm help                Show this help menu.")
-	fmt.Println("  dcm setup               Git checkout repositories for the services that require")
-	fmt.Println("                          local docker build. It skips the service when the image")
-	fmt.Println("                          is from docker hub, or the repo's folder already exists.")
-	fmt.Println("  dcm run [<args>]        Run docker-compose commands. If <args> is not given, by")
-	fmt.Println("                          default DCM will run `docker-compose up` command.")
-	fmt.Println("                          <args>: up, build, start, stop, restart, pre-init, init, execute")
-	fmt.Println("  dcm build               Docker (re)build service images that require local build.")
-	fmt.Println("                          It's the shorthand version of `dcm run build` command.")
-	fmt.Println("  dcm shell <service>     Log into a given service container.")
-	fmt.Println("  dcm purge [<type>]      Remove either all the containers or all the images. If <type>")
-	fmt.Println("                          is not given, by default DCM will purge everything.")
-	fmt.Println("                          <type>: images, containers, all")
-	fmt.Println("  dcm branch [<service>]  Display the current git branch for the given service that")
-	fmt.Println("                          was built locally.")
-	fmt.Println("  dcm goto [<service>]    Go to the service's folder. If <service> is not given, by")
-	fmt.Println("                          default DCM will go to $DCM_DIR.")
-	fmt.Println("  dcm update [<service>]  Update DCM and(or) the given service.")
-	fmt.Println("  dcm list                List all the available services.")
-	fmt.Println("")
-	fmt.Println("Example:")
-	fmt.Println("  Initial setup")
-	fmt.Println("    dcm setup")
-	fmt.Println("    dcm run")
-	fmt.Println("")
-	fmt.Println("  Rebuild")
-	fmt.Println("    dcm build")
-	fmt.Println("    dcm run")
-	fmt.Println("")
-	fmt.Println("  Or only Rerun")
-	fmt.Println("    dcm run")
-	fmt.Println("")
-	fmt.Println("  Log into a service's container")
-	fmt.Println("    dcm shell service_name")
-	fmt.Println("")
+func (d *Dcm) List(args ...string) (int, error) {
+	output, args, err := parseOutputOption(args, outputText)
+	if err != nil {
+		return 1, err
+	}
+	if err := checkOutputFormat(output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return 1, err
+	}
+	if _, err := d.setSelector(args, 0); err != nil {
+		return 1, err
+	}
+
+	if output == outputText {
+		return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+			fmt.Fprintln(d.Stdout, s.Name)
+			return 0, nil
+		})
+	}
+
+	services, err := d.getSelectedServices()
+	if err != nil {
+		return 1, err
+	}
+	all, err := d.Config.Services()
+	if err != nil {
+		return 1, err
+	}
+	// The groups come from both the labels and the settings
+	memberOf := map[string][]string{}
+	for group, members := range getGroups(all, d.Config.Settings) {
+		for _, name := range members {
+			memberOf[name] = append(memberOf[name], group)
+		}
+	}
+
+	entries := []listEntry{}
+	for _, s := range services {
+		groups := append([]string{}, memberOf[s.Name]...)
+		sort.Strings(groups)
+		dependsOn := append([]string{}, s.DependsOn...)
+		entries = append(entries, listEntry{
+			Name:       s.Name,
+			Source:     s.Source(),
+			Image:      s.Image,
+			Repository: s.Repository,
+			Groups:     groups,
+			DependsOn:  dependsOn,
+		})
+	}
+
+	if output == outputTable {
+		rows := [][]string{}
+		for _, e := range entries {
+			rows = append(rows, []string{
+				e.Name, e.Source, e.Image, e.Repository,
+				strings.Join(e.Groups, ", "), strings.Join(e.DependsOn, ", "),
+			})
+		}
+		err = writeTable(d.Stdout, []string{"SERVICE", "SOURCE", "IMAGE", "REPOSITORY", "GROUPS", "DEPENDS ON"}, rows)
+	} else {
+		err = writeOutput(d.Stdout, output, entries)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// getSelectedServices returns the services the selector of the command
+// picks, in dependency order
+func (d *Dcm) getSelectedServices() ([]*Service, error) {
+	services, err := d.Config.Services()
+	if err != nil {
+		return nil, err
+	}
+	if services, err = d.selectServices(services); err != nil {
+		return nil, err
+	}
+	order, err := sortServices(services)
+	if err != nil {
+		return nil, err
+	}
+	selected := []*Service{}
+	for _, name := range order {
+		selected = append(selected, services[name])
+	}
+	return selected, nil
 }
@@ -3,10 +3,11 @@ package main
 import (
 	"bytes"
 	"errors"
-	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -30,6 +31,10 @@ func (c *CmdMock) Exec(name string, args ...string) Executable {
 	return c
 }
 
+func (c *CmdMock) Clone() Executable {
+	return &CmdMock{dir: c.dir}
+}
+
 func (c *CmdMock) Setdir(dir string) Executable {
 	c.dir = dir
 	return c
@@ -56,7 +61,7 @@ func (c *CmdMock) Run() error {
 			return errors.New("exit status 1")
 		}
 		if len(c.args) == 3 && c.args[0] == "rev-parse" &&
-			c.dir == "/test/dcm/git/rev-parse/error" {
+			path.Base(c.dir) == "git_rev_parse_error" {
 			return errors.New("exit status 1")
 		}
 		if len(c.args) == 2 && c.args[0] == "checkout" {
@@ -66,11 +71,11 @@ func (c *CmdMock) Run() error {
 			}
 		}
 		if len(c.args) == 1 && c.args[0] == "pull" &&
-			c.dir == "/test/dcm/git/pull/error" {
+			path.Base(c.dir) == "git_pull_error" {
 			return errors.New("exit status 1")
 		}
 	case "docker-compose":
-		if c.dir == "/test/dcm/run/execute/error" {
+		if strings.HasSuffix(c.dir, "/run/execute/error") {
 			return errors.New("exit status 1")
 		}
 	case "/bin/bash":
@@ -83,17 +88,10 @@ func (c *CmdMock) Run() error {
 			c.args[2] == "dcmtest_failed_to_run_docker_exec_1" {
 			return errors.New("exit status 1")
 		}
-		if len(c.args) == 2 && c.args[0] == "rmi" &&
-			c.args[1] == "dcmtest_bad" {
-			return errors.New("exit status 1")
-		}
-		if len(c.args) == 2 && c.args[0] == "kill" &&
-			c.args[1] == "dcmtest_docker_kill_error_1" {
-			return errors.New("exit status 1")
-		}
-		if len(c.args) == 3 && c.args[0] == "rm" &&
-			c.args[2] == "dcmtest_docker_rm_error_1" {
-			return errors.New("exit status 1")
+		if len(c.args) > 0 && c.args[0] == "exec" &&
+			c.args[len(c.args)-1] == "test/dcm/run/init/exit_2" {
+			// A real exit status, for the exit code of the script
+			return helperCommand(nil, "exit").Run()
 		}
 	}
 	return nil
@@ -101,37 +99,106 @@ func (c *CmdMock) Run() error {
 
 func (c *CmdMock) Out() ([]byte, error) {
 	switch c.name {
-	case "docker":
-		if len(c.args) == 3 && c.args[0] == "ps" {
-			switch c.args[2] {
-			case "name=dcmtest_empty_container_id_":
-				return []byte(""), nil
-			case "name=dcmtest_ok_":
-				return []byte("dcmtest_ok_1"), nil
-			case "name=dcmtest_failed_to_run_docker_exec_":
-				return []byte("dcmtest_failed_to_run_docker_exec_1"), nil
-			case "name=dcmtest_docker_kill_error_":
-				return []byte("dcmtest_docker_kill_error_1"), nil
-			case "name=dcmtest_docker_rm_error_":
-				return []byte("dcmtest_docker_rm_error_1"), nil
+	case "git":
+		if len(c.args) == 3 && c.args[0] == "config" {
+			switch path.Base(c.dir) {
+			case "no_remote", "git_rev_parse_error":
+				return []byte(""), errors.New("exit status 1")
+			default:
+				return []byte("git@github.com:dcm/" + path.Base(c.dir) + ".git\n"), nil
+			}
+		}
+		if len(c.args) == 3 && c.args[0] == "rev-parse" {
+			switch path.Base(c.dir) {
+			case "git_rev_parse_error":
+				return []byte("fatal: not a git repository"), errors.New("exit status 128")
+			case "feature":
+				return []byte("feature\n"), nil
+			default:
+				return []byte("develop\n"), nil
+			}
+		}
+		if len(c.args) == 2 && c.args[0] == "status" {
+			if path.Base(c.dir) == "feature" {
+				return []byte(" M main.go\n?? notes.txt\n"), nil
+			}
+			return []byte(""), nil
+		}
+		if len(c.args) == 4 && c.args[0] == "rev-parse" {
+			if path.Base(c.dir) == "feature" {
+				return []byte("fatal: no upstream configured for branch 'feature'"), errors.New("exit status 128")
+			}
+			return []byte("origin/develop\n"), nil
+		}
+		if len(c.args) == 4 && c.args[0] == "rev-list" {
+			return []byte("1\t3\n"), nil
+		}
+		if len(c.args) == 3 && c.args[0] == "log" {
+			return []byte("abc1234 Fix the login form when the session expires\n"), nil
+		}
+		if len(c.args) == 4 && c.args[0] == "symbolic-ref" {
+			switch path.Base(c.dir) {
+			case "git_rev_parse_error":
+				return []byte("fatal: not a git repository"), errors.New("exit status 128")
+			case "detached":
+				return []byte(""), errors.New("exit status 1")
 			default:
-				return []byte("error"), errors.New("exit status 1")
+				return []byte("develop\n"), nil
 			}
 		}
-		if len(c.args) == 1 && c.args[0] == "images" {
-			switch c.dir {
-			case "/test/docker/images/error":
-				return []byte("error"), errors.New("exit status 1")
-			case "/test/docker/images/remove/error":
-				return []byte("dcmtest_bad foobar bazqux"), nil
+	case "docker-compose":
+		if len(c.args) == 2 && c.args[0] == "version" {
+			switch path.Base(c.dir) {
+			case "compose_plugin", "no_compose":
+				return []byte("docker-compose: command not found"), errors.New("exit status 127")
+			case "compose_v2":
+				return []byte("2.21.0\n"), nil
 			default:
-				return []byte("dcmtest_ok foobar bazqux"), nil
+				return []byte("1.29.2\n"), nil
+			}
+		}
+	case "docker":
+		if len(c.args) == 3 && c.args[0] == "compose" && c.args[1] == "version" {
+			if path.Base(c.dir) == "no_compose" {
+				return []byte("docker: 'compose' is not a docker command."), errors.New("exit status 1")
 			}
+			return []byte("v2.24.5\n"), nil
 		}
 	}
 	return []byte(""), nil
 }
 
+// ========== Dcm of a test project as test helper ==========
+
+// helperCreateTestDcm returns a Dcm for the project, or for the default one
+// when empty, in a new temp folder with the given files. The files are keyed
+// by their path in the folder, and the paths ending with a slash are
+// folders. The output goes to the buffer, the commands to a CmdMock, and the
+// caller removes the folder.
+func helperCreateTestDcm(t *testing.T, project string, files map[string]string) (*Dcm, *bytes.Buffer, string) {
+	var out bytes.Buffer
+
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	for name, content := range files {
+		file := dir + "/" + name
+		if strings.HasSuffix(name, "/") {
+			require.Nil(t, os.MkdirAll(file, 0777))
+			continue
+		}
+		require.Nil(t, os.MkdirAll(path.Dir(file), 0777))
+		require.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+	// Left over by the tests of the config file
+	os.Unsetenv("DCM_CONFIG_FILE")
+	os.Unsetenv("DCM_PROJECT")
+
+	dcm := NewDcm(NewConfig().loadOptions(Options{Dir: dir, Project: project}), []string{})
+	dcm.Cmd = &CmdMock{}
+	dcm.Stdout = &out
+	return dcm, &out, dir
+}
+
 // ========== Here starts the real tests for Dcm ==========
 
 func TestCommand(t *testing.T) {
@@ -142,13 +209,19 @@ func TestCommand(t *testing.T) {
 
 	dir, err := ioutil.TempDir("", "dcm")
 	require.Nil(t, err)
-	defer os.Remove(dir)
+	defer os.RemoveAll(dir)
+
+	file := dir + "/dcmtest.yml"
+	// The dcm service has no container to wait for in the fake daemon
+	require.Nil(t, ioutil.WriteFile(file, []byte("x-dcm:\n  wait_timeout: 0\ndcm:\n  image: dcm\n"), 0644))
+	defer os.Remove(file)
+	os.Unsetenv("DCM_CONFIG_FILE")
 
+	engine, server := helperEngine(t, newFakeEngine())
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
-	dcm.Config.Project = "dcmtest"
-	dcm.Config.Dir = dir
 	dcm.Cmd = &CmdMock{}
-	dcm.Cmd.Setdir("/test/dcm/git/rev-parse/ok")
+	dcm.Engine = engine
 
 	tests := []struct {
 		name string
@@ -165,6 +238,11 @@ func TestCommand(t *testing.T) {
 			args: []string{"help"},
 			code: 0,
 		},
+		{
+			name: "test command `dcm validate`",
+			args: []string{"validate"},
+			code: 0,
+		},
 		{
 			name: "test command `dcm setup`",
 			args: []string{"setup"},
@@ -210,19 +288,126 @@ func TestCommand(t *testing.T) {
 			args: []string{"list"},
 			code: 0,
 		},
+		{
+			name: "dcm command `dcm --jobs 2 --keep-going list`",
+			args: []string{"--jobs", "2", "--keep-going", "list"},
+			code: 0,
+		},
+		{
+			name: "test command `dcm help run`",
+			args: []string{"help", "run"},
+			code: 0,
+		},
+		{
+			name: "test option `dcm --help`",
+			args: []string{"--help"},
+			code: 0,
+		},
+		{
+			name: "test option `dcm setup --help`",
+			args: []string{"setup", "--help"},
+			code: 0,
+		},
 		{
 			name: "Invalid args passed, print usage, and return code 127",
 			args: []string{"invalid"},
 			code: 127,
 		},
+		{
+			name: "Invalid option passed, print usage, and return code 127",
+			args: []string{"--invalid", "list"},
+			code: 127,
+		},
 	}
 
 	for n, test := range tests {
-		dcm.Args = test.args
+		dcm.Args = append([]string{"--dir", dir, "--project", "dcmtest"}, test.args...)
 		code, err = dcm.Command()
 		assert.Equal(t, code, test.code, "[%d: %s] Incorrect error code returned", n, test.name)
 		assert.Nil(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 	}
+
+	// Negative case: invalid number of jobs
+	dcm.Args = []string{"--jobs", "0", "list"}
+	code, err = dcm.Command()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Invalid number of jobs: 0")
+
+	// Negative case: config file not found
+	dcm.Jobs = 1
+	dcm.Args = []string{"--config", dir + "/missing.yml", "list"}
+	code, err = dcm.Command()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "open "+dir+"/missing.yml: no such file or directory")
+}
+
+func TestCommandOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, ioutil.WriteFile(dir+"/main.yml", []byte(yamlFixtureSettings), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/local.yml", []byte("x-dcm:\n  jobs: 2\n"), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/envproject.yml", []byte(yamlFixtureSettings), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/flagproject.yml", []byte(yamlFixtureSettings), 0644))
+	os.Unsetenv("DCM_CONFIG_FILE")
+	os.Setenv("DCM_PROJECT", "envproject")
+	defer os.Unsetenv("DCM_PROJECT")
+
+	fixtures := []struct {
+		name    string
+		args    []string
+		project string
+		file    string
+		srv     string
+		jobs    int
+	}{
+		{
+			name:    "The environment and the settings are used without options",
+			args:    []string{"--dir", dir, "list"},
+			project: "envproject",
+			file:    dir + "/envproject.yml",
+			srv:     dir + "/checkouts",
+			jobs:    4,
+		},
+		{
+			name:    "The options take precedence",
+			args:    []string{"--dir", dir, "--project", "flagproject", "--srv", dir + "/srv", "--jobs", "3", "list"},
+			project: "flagproject",
+			file:    dir + "/flagproject.yml",
+			srv:     dir + "/srv",
+			jobs:    3,
+		},
+		{
+			name:    "Repeated config files are merged in order",
+			args:    []string{"--dir", dir, "--config", dir + "/main.yml", "--config", dir + "/local.yml", "list"},
+			project: "envproject",
+			file:    dir + "/main.yml",
+			srv:     dir + "/checkouts",
+			jobs:    2,
+		},
+		{
+			name:    "The options apply to the commands not loading the config",
+			args:    []string{"--dir", dir, "--project", "newproject", "init", "--from", dir + "/main.yml"},
+			project: "newproject",
+			file:    dir + "/newproject.yml",
+			srv:     dir + "/srv/newproject",
+			jobs:    1,
+		},
+	}
+
+	for n, test := range fixtures {
+		dcm := NewDcm(NewConfig(), test.args)
+		dcm.Stdout = ioutil.Discard
+		code, err := dcm.Command()
+		assert.Equal(t, 0, code, "[%d: %s] Incorrect error code returned", n, test.name)
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, dir, dcm.Config.Dir, "[%d: %s] Incorrect dir", n, test.name)
+		assert.Equal(t, test.project, dcm.Config.Project, "[%d: %s] Incorrect project", n, test.name)
+		assert.Equal(t, test.srv, dcm.Config.Srv, "[%d: %s] Incorrect srv", n, test.name)
+		assert.Equal(t, test.file, dcm.Config.File, "[%d: %s] Incorrect file", n, test.name)
+		assert.Equal(t, test.jobs, dcm.Jobs, "[%d: %s] Incorrect number of jobs", n, test.name)
+	}
 }
 
 func TestSetup(t *testing.T) {
@@ -263,6 +448,22 @@ func TestSetup(t *testing.T) {
 			code: 1,
 			err:  errors.New("exit status 1"),
 		},
+		{
+			name: "Negative case: image built from the checkout, failed to clone git repository",
+			config: yamlConfig{
+				"service": yamlConfig{
+					"image": "username/service",
+					// As set by setImplicitBuilds for dcm.dockerfile
+					"build": yamlConfig{"context": "./srv/service", "dockerfile": "docker/Dockerfile"},
+					"labels": yamlConfig{
+						"dcm.repository": "test-dcm-setup-error",
+						"dcm.dockerfile": "docker/Dockerfile",
+					},
+				},
+			},
+			code: 1,
+			err:  errors.New("Error cloning git repository for service [service]: exit status 1"),
+		},
 		{
 			name: "Positive case: success with docker hub image",
 			config: yamlConfig{
@@ -273,6 +474,17 @@ func TestSetup(t *testing.T) {
 			code: 0,
 			err:  nil,
 		},
+		{
+			name: "Positive case: pulled image, the repository is not cloned",
+			config: yamlConfig{
+				"service": yamlConfig{
+					"image":  "docker-hub-image",
+					"labels": yamlConfig{"dcm.repository": "test-dcm-setup-error"},
+				},
+			},
+			code: 0,
+			err:  nil,
+		},
 		{
 			name: "Positive case: success with local build",
 			config: yamlConfig{
@@ -334,7 +546,7 @@ func TestDoForEachService(t *testing.T) {
 
 	// Negative case: silently fail when encountering bad config
 	dcm.Config.Config = fixtureBad
-	doSrv = func(service string, configs yamlConfig) (int, error) {
+	doSrv = func(d *Dcm, s *Service) (int, error) {
 		return 0, nil
 	}
 	code, err = dcm.doForEachService(doSrv)
@@ -343,21 +555,47 @@ func TestDoForEachService(t *testing.T) {
 
 	// Negative case: fail with error
 	dcm.Config.Config = fixtureGood
-	doSrv = func(service string, configs yamlConfig) (int, error) {
+	doSrv = func(d *Dcm, s *Service) (int, error) {
 		return 1, errors.New("Error")
 	}
 	code, err = dcm.doForEachService(doSrv)
 	assert.Equal(t, 1, code)
 	assert.Error(t, err)
 
+	// Negative case: fail on dependency cycle
+	dcm.Config.Config = yamlConfig{
+		"srv1": yamlConfig{"depends_on": []interface{}{"srv2"}},
+		"srv2": yamlConfig{"depends_on": []interface{}{"srv1"}},
+	}
+	code, err = dcm.doForEachService(doSrv)
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Dependency cycle detected between services: srv1 -> srv2 -> srv1")
+
 	// Positive case: success
 	dcm.Config.Config = fixtureGood
-	doSrv = func(service string, configs yamlConfig) (int, error) {
+	doSrv = func(d *Dcm, s *Service) (int, error) {
 		return 0, nil
 	}
 	code, err = dcm.doForEachService(doSrv)
 	assert.Equal(t, 0, code)
 	assert.NoError(t, err)
+
+	// Positive case: services are visited in dependency order
+	visited := []string{}
+	dcm.Config.Config = yamlConfig{
+		"api":    yamlConfig{"depends_on": []interface{}{"db"}},
+		"db":     yamlConfig{},
+		"admin":  yamlConfig{"links": []interface{}{"api"}},
+		"worker": yamlConfig{},
+	}
+	doSrv = func(d *Dcm, s *Service) (int, error) {
+		visited = append(visited, s.Name)
+		return 0, nil
+	}
+	code, err = dcm.doForEachService(doSrv)
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db", "api", "admin", "worker"}, visited)
 }
 
 func TestRun(t *testing.T) {
@@ -366,7 +604,12 @@ func TestRun(t *testing.T) {
 		err  error
 	)
 
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
 	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Config.Dir = dir
 	dcm.Cmd = &CmdMock{}
 
 	tests := []struct {
@@ -429,18 +672,28 @@ func TestRun(t *testing.T) {
 }
 
 func TestRunExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, ioutil.WriteFile(dir+"/file", []byte(""), 0644))
+
 	fixtures := []struct {
 		name, dir string
 		code      int
 	}{
 		{
 			name: "Negative case: failed to run docker-compose command",
-			dir:  "/test/dcm/run/execute/error",
+			dir:  dir + "/run/execute/error",
+			code: 1,
+		},
+		{
+			name: "Negative case: failed to render the compose file",
+			dir:  dir + "/file",
 			code: 1,
 		},
 		{
 			name: "Positive case: success",
-			dir:  "/test/dcm/run/execute/ok",
+			dir:  dir + "/run/execute/ok",
 			code: 0,
 		},
 	}
@@ -458,6 +711,26 @@ func TestRunExecute(t *testing.T) {
 			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 		}
 	}
+	// Positive case: the compose binary comes with arguments of its own
+	mock := &CmdMock{}
+	dcm.Cmd = mock
+	dcm.Config.Settings.ComposeBinary = "docker compose"
+	dcm.Compose = nil
+	code, err := dcm.runExecute("up", "-d")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "docker", mock.name)
+	assert.Equal(t, []string{"compose", "--project-directory", dcm.Config.Dir, "up", "-d"}, mock.args)
+
+	// Positive case: the rendered config is passed to compose, rather than
+	// the config files
+	dcm.Config.Files = []string{dir + "/dcm.yml", dir + "/dcm.local.yml"}
+	code, err = dcm.runExecute("up", "-d")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Contains(t, mock.env, "COMPOSE_FILE="+dcm.Config.Dir+"/.dcm/"+dcm.Config.Project+"/docker-compose.yml")
+	assert.Contains(t, mock.env, "DCM_PROJECT="+dcm.Config.Project)
+	assert.Contains(t, mock.env, "DCM_SRV="+dcm.Config.Srv)
 }
 
 func TestRunInit(t *testing.T) {
@@ -520,6 +793,86 @@ func TestRunInit(t *testing.T) {
 	}
 }
 
+func TestRunInitInContainer(t *testing.T) {
+	engine, server := helperEngine(t, newFakeEngine())
+	defer server.Close()
+
+	mock := &CmdMock{}
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Cmd = mock
+	dcm.Engine = engine
+	dcm.Config.Project = "dcmtest"
+	dcm.Config.Srv = "/test/dcm/srv"
+
+	// Positive case: the init script in the container, as the given user and
+	// in the given folder
+	dcm.Config.Config = yamlConfig{
+		"ok": yamlConfig{
+			"labels": yamlConfig{
+				"dcm.initscript":         "bin/init.sh",
+				"dcm.initscript_target":  "container",
+				"dcm.initscript_shell":   "/bin/sh",
+				"dcm.initscript_user":    "www-data",
+				"dcm.initscript_workdir": "/app",
+			},
+		},
+	}
+	code, err := dcm.runInit()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "docker", mock.name)
+	assert.Equal(t, []string{"exec", "--user", "www-data", "--workdir", "/app", "dcmtest_ok_1", "/bin/sh", "bin/init.sh"}, mock.args)
+
+	// Positive case: the container init script runs after the one on the host
+	dcm.Config.Config = yamlConfig{
+		"ok": yamlConfig{
+			"labels": yamlConfig{
+				"dcm.initscript":           "test/dcm/run/init/ok",
+				"dcm.container_initscript": "/app/bin/seed.sh",
+			},
+		},
+	}
+	code, err = dcm.runInit()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"exec", "dcmtest_ok_1", "/bin/bash", "/app/bin/seed.sh"}, mock.args)
+
+	// Negative case: the host init script failed, the container one is not run
+	mock.args = nil
+	dcm.Config.Config = yamlConfig{
+		"ok": yamlConfig{
+			"labels": yamlConfig{
+				"dcm.initscript":           "test/dcm/run/init/error",
+				"dcm.container_initscript": "/app/bin/seed.sh",
+			},
+		},
+	}
+	code, err = dcm.runInit()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Error executing init script [test/dcm/run/init/error] for service [ok]: exit status 1")
+	assert.Equal(t, []string{"test/dcm/run/init/error"}, mock.args)
+
+	// Negative case: the exit code of the script is returned
+	dcm.Config.Config = yamlConfig{
+		"ok": yamlConfig{
+			"labels": yamlConfig{"dcm.container_initscript": "test/dcm/run/init/exit_2"},
+		},
+	}
+	code, err = dcm.runInit()
+	assert.Equal(t, 2, code)
+	assert.EqualError(t, err, "Error executing init script [test/dcm/run/init/exit_2] in container [dcmtest_ok_1] for service [ok]: exit status 2")
+
+	// Negative case: no running container
+	dcm.Config.Config = yamlConfig{
+		"missing": yamlConfig{
+			"labels": yamlConfig{"dcm.container_initscript": "/app/bin/seed.sh"},
+		},
+	}
+	code, err = dcm.runInit()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "No running container found for service [missing]")
+}
+
 func TestRunPreInit(t *testing.T) {
 	fixtures := []struct {
 		name   string
@@ -570,7 +923,7 @@ func TestRunPreInit(t *testing.T) {
 
 	for n, test := range fixtures {
 		dcm.Config.Config = test.config
-		code, err := dcm.runInit()
+		code, err := dcm.runPreInit()
 		assert.Equal(t, test.code, code, "[%d: %s] Incorrect error code returned", n, test.name)
 		if test.err != nil {
 			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
@@ -587,54 +940,55 @@ func TestDir(t *testing.T) {
 	require.Nil(t, err)
 	defer os.RemoveAll(dir)
 
+	var out bytes.Buffer
 	dcm := NewDcm(NewConfig(), []string{})
-	var out string
-
-	// Test Dir() without args
-	out = helperTestOsStdout(t, func() {
-		dcm.Config.Dir = dir
-		dcm.Dir()
-	})
-	assert.Equal(t, dir, out)
-
-	// Test Dir() with args, not exists, fall back to dcm.Config.Dir
-	out = helperTestOsStdout(t, func() {
-		dcm.Config.Srv = dir
-		dcm.Dir("not_exists")
-	})
-	assert.Equal(t, dir, out)
-
-	// Test Dir() with args
-	out = helperTestOsStdout(t, func() {
-		dcm.Config.Srv = dir
-		dcm.Dir(path.Base(srv))
-	})
-	assert.Equal(t, srv, out)
-}
-
-func helperTestOsStdout(t *testing.T, fn func()) (out string) {
-	// Capture stdout
-	stdout := os.Stdout
-	r, w, err := os.Pipe()
-	require.Nil(t, err)
-	os.Stdout = w
-	outC := make(chan string)
-
-	go func() {
-		var buf bytes.Buffer
-		_, err := io.Copy(&buf, r)
-		r.Close()
-		require.Nil(t, err)
-		outC <- buf.String()
-	}()
+	dcm.Stdout = &out
+	dcm.Config.Dir = dir
+	dcm.Config.Srv = dir
 
-	fn()
+	fixtures := []struct {
+		name string
+		args []string
+		out  string
+	}{
+		{
+			name: "Without args",
+			args: []string{},
+			out:  dir,
+		},
+		{
+			name: "With args, not exists, fall back to dcm.Config.Dir",
+			args: []string{"not_exists"},
+			out:  dir,
+		},
+		{
+			name: "With args",
+			args: []string{path.Base(srv)},
+			out:  srv,
+		},
+		{
+			name: "As JSON",
+			args: []string{path.Base(srv), "-o", "json"},
+			out:  "{\n  \"service\": \"" + path.Base(srv) + "\",\n  \"dir\": \"" + srv + "\"\n}\n",
+		},
+		{
+			name: "As a table",
+			args: []string{"--output", "table"},
+			out:  "SERVICE  DIR\n-        " + dir + "\n",
+		},
+	}
 
-	w.Close()
-	os.Stdout = stdout
-	out = <-outC
+	for n, test := range fixtures {
+		out.Reset()
+		code, err := dcm.Dir(test.args...)
+		assert.Equal(t, 0, code, "[%d: %s] Incorrect error code returned", n, test.name)
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.out, out.String(), "[%d: %s] Incorrect output", n, test.name)
+	}
 
-	return
+	code, err := dcm.Dir("-o", "xml")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Invalid output format [xml], must be one of: text, table, json, yaml")
 }
 
 func TestShell(t *testing.T) {
@@ -643,8 +997,12 @@ func TestShell(t *testing.T) {
 		err  error
 	)
 
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
 	dcm.Config.Project = "dcmtest"
 
 	// Negative case: failed when there is no arg passed
@@ -652,10 +1010,12 @@ func TestShell(t *testing.T) {
 	assert.Equal(t, 1, code)
 	assert.EqualError(t, err, "Error: no service name specified.")
 
-	// Negative case: failed to get docker container id
-	code, err = dcm.Shell("failed_to_get_container_id")
+	// Negative case: failed to list the containers
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	code, err = dcm.Shell("ok")
 	assert.Equal(t, 1, code)
-	assert.EqualError(t, err, "exit status 1: error")
+	assert.EqualError(t, err, "Error response from daemon: fake error for /containers/json")
+	delete(f.errors, "GET /containers/json")
 
 	// Negative case: failed to run docker exec command
 	code, err = dcm.Shell("failed_to_run_docker_exec")
@@ -666,82 +1026,71 @@ func TestShell(t *testing.T) {
 	code, err = dcm.Shell("ok")
 	assert.Equal(t, 0, code)
 	assert.NoError(t, err)
-}
-
-func TestGetContainerId(t *testing.T) {
-	fixtures := []struct {
-		name, service, cid string
-		err                error
-	}{
-		{
-			name:    "Negative case: failed to get docker container id",
-			service: "docker_ps_error",
-			cid:     "",
-			err:     errors.New("exit status 1: error"),
-		},
-		{
-			name:    "Negative case: got an empty container id",
-			service: "empty_container_id",
-			cid:     "",
-			err:     nil,
-		},
-		{
-			name:    "Positive case: success",
-			service: "ok",
-			cid:     "dcmtest_ok_1",
-			err:     nil,
-		},
+	// Positive case: defaults to the service of the working directory
+	mock := &CmdMock{}
+	dcm.Cmd = mock
+	dcm.Config.CurrentService = "ok"
+	code, err = dcm.Shell()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"exec", "-it", "dcmtest_ok_1", "bash"}, mock.args)
+
+	// Positive case: a given replica, with the option before or after the
+	// service
+	for _, args := range [][]string{{"--index", "3", "ok"}, {"ok", "--index=3"}} {
+		code, err = dcm.Shell(args...)
+		assert.Equal(t, 0, code)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"exec", "-it", "dcmtest_ok_3", "bash"}, mock.args)
 	}
 
-	dcm := NewDcm(NewConfig(), []string{})
-	dcm.Cmd = &CmdMock{}
-	dcm.Config.Project = "dcmtest"
-
-	for n, test := range fixtures {
-		cid, err := dcm.getContainerId(test.service, "-qf")
-		assert.Equal(t, test.cid, cid, "[%d: %s] Incorrect docker container ID returned", n, test.name)
-		if test.err != nil {
-			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
-		} else {
-			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
-		}
-	}
+	// Negative cases: the replica is not running, or there is none
+	code, err = dcm.Shell("ok", "--index", "2")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "No running container [2] found for service [ok]")
+	code, err = dcm.Shell("missing")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "No running container found for service [missing]")
+	code, err = dcm.Shell("ok", "--index", "first")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, `Error parsing options: invalid value "first" for flag -index: parse error`)
 }
 
 func TestGetImageRepository(t *testing.T) {
 	fixtures := []struct {
-		name, dir, service, repo string
-		err                      error
+		name, service, repo string
+		err                 error
 	}{
 		{
-			name:    "Negative case: failed to execute docker images",
-			dir:     "/test/docker/images/error",
+			name:    "Negative case: service image repo name is not in docker images list",
 			service: "empty_image_repo",
 			repo:    "",
-			err:     errors.New("exit status 1: error"),
+			err:     nil,
 		},
 		{
-			name:    "Negative case: service image repo name is not in docker images list",
-			dir:     "/test/docker/images/ok",
-			service: "empty_image_repo",
+			name:    "Negative case: only another service shares the name prefix",
+			service: "foo",
 			repo:    "",
 			err:     nil,
 		},
 		{
 			name:    "Positive case: success",
-			dir:     "/test/docker/images/ok",
 			service: "ok",
 			repo:    "dcmtest_ok",
 			err:     nil,
 		},
 	}
 
+	f := newFakeEngine()
+	f.images = append(f.images, EngineImage{ID: "sha256:4", RepoTags: []string{"dcmtest_foobar:latest"}})
+	engine, server := helperEngine(t, f)
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
 	dcm.Config.Project = "dcmtest"
 
 	for n, test := range fixtures {
-		dcm.Cmd.Setdir(test.dir)
 		repo, err := dcm.getImageRepository(test.service)
 		assert.Equal(t, test.repo, repo, "[%d: %s] Incorrect docker image repository name returned", n, test.name)
 		if test.err != nil {
@@ -750,6 +1099,72 @@ func TestGetImageRepository(t *testing.T) {
 			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 		}
 	}
+
+	// Negative case: failed to list the images
+	f.errors["GET /images/json"] = http.StatusInternalServerError
+	_, err := dcm.getImageRepository("ok")
+	assert.EqualError(t, err, "Error response from daemon: fake error for /images/json")
+}
+
+func TestBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.Mkdir(dir+"/feature", 0777))
+
+	var out bytes.Buffer
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Cmd = &CmdMock{}
+	dcm.Stdout = &out
+	dcm.Config.Dir = dir
+	dcm.Config.Srv = dir
+	dcm.Config.Config = yamlConfig{
+		"feature": yamlConfig{"labels": yamlConfig{"dcm.repository": "git@github.com:username/feature.git"}},
+		"missing": yamlConfig{"build": "./missing"},
+		"web":     yamlConfig{"image": "nginx"},
+	}
+
+	code, err := dcm.Branch("feature")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "feature: Git repo: git@github.com:username/feature.git, branch: feature\n", out.String())
+
+	out.Reset()
+	code, err = dcm.Branch("-o", "table")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SERVICE  SOURCE  IMAGE  REPOSITORY                           BRANCH   ERROR\n"+
+		"dcm      repo    -      -                                    develop  -\n"+
+		"feature  repo    -      git@github.com:username/feature.git  feature  -\n"+
+		"missing  build   -      -                                    -        stat "+dir+"/missing: no such file or directory\n"+
+		"web      image   nginx  -                                    -        -\n", out.String())
+
+	out.Reset()
+	code, err = dcm.Branch("web", "--output", "json")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n  {\n    \"service\": \"web\",\n    \"source\": \"image\",\n    \"image\": \"nginx\"\n  }\n]\n", out.String())
+
+	code, err = dcm.Branch("-o", "xml")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Invalid output format [xml], must be one of: text, table, json, yaml")
+
+	// Several services are shown without the ones they depend on, like one
+	dcm.Config.Config["feature"] = yamlConfig{
+		"depends_on": []interface{}{"web"},
+		"labels":     yamlConfig{"dcm.repository": "git@github.com:username/feature.git"},
+	}
+	out.Reset()
+	dcm.Selector = selector{}
+	code, err = dcm.Branch("feature", "missing", "-o", "table")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SERVICE  SOURCE  IMAGE  REPOSITORY                           BRANCH   ERROR\n"+
+		"dcm      repo    -      -                                    develop  -\n"+
+		"feature  repo    -      git@github.com:username/feature.git  feature  -\n"+
+		"missing  build   -      -                                    -        stat "+dir+"/missing: no such file or directory\n", out.String())
 }
 
 func TestBranchForOne(t *testing.T) {
@@ -763,22 +1178,23 @@ func TestBranchForOne(t *testing.T) {
 	srv, err := ioutil.TempDir(dir, "service")
 	require.Nil(t, err)
 	defer os.RemoveAll(dir)
+	bad := dir + "/git_rev_parse_error"
+	require.Nil(t, os.Mkdir(bad, 0777))
 
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
 
-	// Negative case: get dcm branch failed at os.Chdir()
+	// Negative case: get dcm branch failed when dcm dir not exists
 	dcm.Config.Dir = "/fake/dcm/dir"
 	code, err = dcm.branchForOne("dcm")
 	assert.Equal(t, 0, code)
-	assert.EqualError(t, err, "chdir /fake/dcm/dir: no such file or directory")
+	assert.EqualError(t, err, "stat /fake/dcm/dir: no such file or directory")
 
 	// Negative case: git failed to get dcm branch
-	dcm.Config.Dir = dir
-	dcm.Cmd.Setdir("/test/dcm/git/rev-parse/error")
+	dcm.Config.Dir = bad
 	code, err = dcm.branchForOne("dcm")
 	assert.Equal(t, 0, code)
-	assert.EqualError(t, err, "exit status 1")
+	assert.EqualError(t, err, "exit status 128: fatal: not a git repository")
 
 	// Negative case: service not exists
 	dcm.Config.Srv = "/fake/dcm/srv"
@@ -786,20 +1202,19 @@ func TestBranchForOne(t *testing.T) {
 	assert.Equal(t, 0, code)
 	assert.EqualError(t, err, "Service not exists.")
 
-	// Negative case: get service branch failed at os.Chdir()
+	// Negative case: get service branch failed when service dir not exists
 	dcm.Config.Srv = "/fake/dcm/srv"
 	dcm.Config.Config = yamlConfig{"service": yamlConfig{}}
 	code, err = dcm.branchForOne("service")
 	assert.Equal(t, 0, code)
-	assert.EqualError(t, err, "chdir /fake/dcm/srv/service: no such file or directory")
+	assert.EqualError(t, err, "stat /fake/dcm/srv/service: no such file or directory")
 
 	// Negative case: git failed to get service branch
 	dcm.Config.Srv = dir
-	dcm.Config.Config = yamlConfig{path.Base(srv): yamlConfig{}}
-	dcm.Cmd.Setdir("/test/dcm/git/rev-parse/error")
-	code, err = dcm.branchForOne(path.Base(srv))
+	dcm.Config.Config = yamlConfig{path.Base(bad): yamlConfig{}}
+	code, err = dcm.branchForOne(path.Base(bad))
 	assert.Equal(t, 0, code)
-	assert.EqualError(t, err, "exit status 1")
+	assert.EqualError(t, err, "exit status 128: fatal: not a git repository")
 
 	// Positive case: success with a service using docker hub image
 	dcm.Config.Config = yamlConfig{"service": yamlConfig{"image": "docker-hub-image"}}
@@ -807,36 +1222,89 @@ func TestBranchForOne(t *testing.T) {
 	assert.Equal(t, 0, code)
 	assert.NoError(t, err)
 
+	// Positive case: success with a service using local build
+	dcm.Config.Config = yamlConfig{path.Base(srv): yamlConfig{}}
+	code, err = dcm.branchForOne(path.Base(srv))
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+
 	// Positive case: success with dcm branch
 	dcm.Config.Dir = dir
-	dcm.Cmd.Setdir("/test/dcm/git/rev-parse/ok")
 	code, err = dcm.branchForOne("dcm")
 	assert.Equal(t, 0, code)
 	assert.NoError(t, err)
 }
 
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.Mkdir(dir+"/api", 0777))
+	require.Nil(t, os.Mkdir(dir+"/worker", 0777))
+
+	var out bytes.Buffer
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Cmd = &CmdMock{}
+	dcm.Stdout = &out
+	dcm.Config.Srv = dir
+	dcm.Config.Config = yamlConfig{
+		"api": yamlConfig{
+			"depends_on": []interface{}{"db"},
+			"labels":     yamlConfig{"dcm.branch": "test-dcm-update-ok"},
+		},
+		"worker": yamlConfig{
+			"depends_on": []interface{}{"db"},
+			"labels":     yamlConfig{"dcm.branch": "test-dcm-update-ok"},
+		},
+		// Pulling the image would fail without a docker daemon
+		"db": yamlConfig{"image": "postgres"},
+	}
+
+	// Positive case: one service is updated alone
+	code, err := dcm.Update("api")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "api: ", out.String())
+
+	// Positive case: several services are updated alone too
+	out.Reset()
+	code, err = dcm.Update("api", "worker")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "api: worker: ", out.String())
+}
+
 func TestUpdateForOne(t *testing.T) {
 	dir, err := ioutil.TempDir("", "dcm")
 	require.Nil(t, err)
 	srv, err := ioutil.TempDir(dir, "service")
 	require.Nil(t, err)
 	defer os.RemoveAll(dir)
+	require.Nil(t, os.Mkdir(dir+"/git_pull_error", 0777))
 
 	service := path.Base(srv)
 
+	// No credentials to look for the image pulls
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
+
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
+	dcm.Stdout = ioutil.Discard
 
 	fixtures := []struct {
-		name, srv, dir string
-		config         yamlConfig
-		service        string
-		code           int
-		err            error
+		name, srv string
+		config    yamlConfig
+		service   string
+		code      int
+		err       error
 	}{
 		{
 			name:    "Negative case: service not exists",
-			dir:     "",
 			srv:     "",
 			config:  yamlConfig{},
 			service: "invalid",
@@ -845,7 +1313,6 @@ func TestUpdateForOne(t *testing.T) {
 		},
 		{
 			name: "Negative case: service not updateable",
-			dir:  "",
 			srv:  "",
 			config: yamlConfig{
 				"service": yamlConfig{
@@ -859,8 +1326,21 @@ func TestUpdateForOne(t *testing.T) {
 			err:     errors.New("Service not updateable. Skipping the update."),
 		},
 		{
-			name: "Negative case: failed to os.Chdir()",
-			dir:  "/test/dcm/update",
+			name: "Negative case: service not updateable, flagged with a YAML bool",
+			srv:  "",
+			config: yamlConfig{
+				"service": yamlConfig{
+					"labels": yamlConfig{
+						"dcm.updateable": false,
+					},
+				},
+			},
+			service: "service",
+			code:    0,
+			err:     errors.New("Service not updateable. Skipping the update."),
+		},
+		{
+			name: "Negative case: service folder not exists",
 			srv:  "/test/dcm/dir/srv/testproj",
 			config: yamlConfig{
 				"invalid": yamlConfig{
@@ -869,11 +1349,10 @@ func TestUpdateForOne(t *testing.T) {
 			},
 			service: "invalid",
 			code:    0,
-			err:     errors.New("chdir /test/dcm/dir/srv/testproj/invalid: no such file or directory"),
+			err:     errors.New("stat /test/dcm/dir/srv/testproj/invalid: no such file or directory"),
 		},
 		{
 			name: "Negative case: cannot read default branch config, use master instead, and got `git checkout` error",
-			dir:  "/test/dcm/update",
 			srv:  dir,
 			config: yamlConfig{
 				service: yamlConfig{
@@ -888,7 +1367,6 @@ func TestUpdateForOne(t *testing.T) {
 		},
 		{
 			name: "Negative case: failed to execute `git checkout`",
-			dir:  "/test/dcm/update",
 			srv:  dir,
 			config: yamlConfig{
 				service: yamlConfig{
@@ -903,22 +1381,50 @@ func TestUpdateForOne(t *testing.T) {
 		},
 		{
 			name: "Negative case: failed to execute `git pull`",
-			dir:  "/test/dcm/git/pull/error",
 			srv:  dir,
 			config: yamlConfig{
-				service: yamlConfig{
+				"git_pull_error": yamlConfig{
 					"labels": yamlConfig{
 						"dcm.branch": "test-dcm-update-ok",
 					},
 				},
 			},
+			service: "git_pull_error",
+			code:    0,
+			err:     errors.New("exit status 1"),
+		},
+		{
+			name: "Negative case: image built from the checkout, failed to execute `git checkout`",
+			srv:  dir,
+			config: yamlConfig{
+				service: yamlConfig{
+					"image": "missing:1.0",
+					"build": yamlConfig{"context": "./srv/" + service, "target": "dev"},
+					"labels": yamlConfig{
+						"dcm.repository":   "git@github.com:username/service.git",
+						"dcm.branch":       "test-dcm-update-error",
+						"dcm.build_target": "dev",
+					},
+				},
+			},
 			service: service,
 			code:    0,
 			err:     errors.New("exit status 1"),
 		},
+		{
+			name: "Negative case: failed to pull the image",
+			srv:  "",
+			config: yamlConfig{
+				"service": yamlConfig{
+					"image": "missing:1.0",
+				},
+			},
+			service: "service",
+			code:    0,
+			err:     errors.New("Error pulling image [missing:1.0]: manifest for missing:1.0 not found"),
+		},
 		{
 			name: "Positive case: success with docker hub image",
-			dir:  "",
 			srv:  "",
 			config: yamlConfig{
 				"service": yamlConfig{
@@ -931,7 +1437,6 @@ func TestUpdateForOne(t *testing.T) {
 		},
 		{
 			name: "Positive case: success with local build",
-			dir:  "/test/dcm/update",
 			srv:  dir,
 			config: yamlConfig{
 				service: yamlConfig{
@@ -947,7 +1452,6 @@ func TestUpdateForOne(t *testing.T) {
 	}
 
 	for n, test := range fixtures {
-		dcm.Cmd.Setdir(test.dir)
 		dcm.Config.Srv = test.srv
 		dcm.Config.Config = test.config
 		code, err := dcm.updateForOne(test.service)
@@ -958,6 +1462,13 @@ func TestUpdateForOne(t *testing.T) {
 			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 		}
 	}
+	// Positive case: the default branch comes from the project settings
+	dcm.Config.Srv = dir
+	dcm.Config.Config = yamlConfig{service: yamlConfig{}}
+	dcm.Config.Settings.DefaultBranch = "develop"
+	code, err := dcm.updateForOne(service)
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
 }
 
 func TestPurge(t *testing.T) {
@@ -966,8 +1477,11 @@ func TestPurge(t *testing.T) {
 		err  error
 	)
 
+	engine, server := helperEngine(t, newFakeEngine())
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
 
 	tests := []struct {
 		name string
@@ -1005,14 +1519,13 @@ func TestPurge(t *testing.T) {
 
 func TestPurgeImages(t *testing.T) {
 	fixtures := []struct {
-		name, dir string
-		config    yamlConfig
-		code      int
-		err       error
+		name   string
+		config yamlConfig
+		code   int
+		err    error
 	}{
 		{
-			name: "Negative case: failed to get image repo name",
-			dir:  "/test/docker/images/error",
+			name: "Negative case: no image for the service",
 			config: yamlConfig{
 				"service": yamlConfig{
 					"test": "purgeImages",
@@ -1022,8 +1535,7 @@ func TestPurgeImages(t *testing.T) {
 			err:  nil,
 		},
 		{
-			name: "Negative case: failed to execute `docker rmi`",
-			dir:  "/test/docker/images/remove/error",
+			name: "Negative case: failed to remove the image",
 			config: yamlConfig{
 				"bad": yamlConfig{
 					"test": "purgeImages",
@@ -1034,7 +1546,6 @@ func TestPurgeImages(t *testing.T) {
 		},
 		{
 			name: "Positive case: success",
-			dir:  "/test/docker/images/ok",
 			config: yamlConfig{
 				"ok": yamlConfig{
 					"test": "purgeImages",
@@ -1045,12 +1556,15 @@ func TestPurgeImages(t *testing.T) {
 		},
 	}
 
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
 	dcm.Config.Project = "dcmtest"
 
 	for n, test := range fixtures {
-		dcm.Cmd.Setdir(test.dir)
 		dcm.Config.Config = test.config
 		code, err := dcm.purgeImages()
 		assert.Equal(t, test.code, code, "[%d: %s] Incorrect error code returned", n, test.name)
@@ -1060,6 +1574,10 @@ func TestPurgeImages(t *testing.T) {
 			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 		}
 	}
+	assert.Equal(t, []EngineImage{
+		{ID: "sha256:2", RepoTags: []string{"dcmtest_bad:latest"}},
+		{ID: "sha256:3", RepoTags: []string{"foobar:latest"}},
+	}, f.images)
 }
 
 func TestPurgeContainers(t *testing.T) {
@@ -1070,9 +1588,9 @@ func TestPurgeContainers(t *testing.T) {
 		err    error
 	}{
 		{
-			name: "Negative case: failed to get container id",
+			name: "Positive case: no container",
 			config: yamlConfig{
-				"docker_ps_error": yamlConfig{
+				"missing": yamlConfig{
 					"test": "purgeContainers",
 				},
 			},
@@ -1111,8 +1629,12 @@ func TestPurgeContainers(t *testing.T) {
 		},
 	}
 
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
 	dcm := NewDcm(NewConfig(), []string{})
 	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
 	dcm.Config.Project = "dcmtest"
 
 	for n, test := range fixtures {
@@ -1125,26 +1647,80 @@ func TestPurgeContainers(t *testing.T) {
 			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
 		}
 	}
+
+	// The replicas are gone, the stopped one included, but the ones
+	// failing to be killed or removed
+	var left []string
+	for _, c := range f.containers {
+		left = append(left, c.ID)
+	}
+	assert.Equal(t, []string{
+		"dcmtest_ok-worker_1",
+		"dcmtest_failed_to_run_docker_exec_1",
+		"dcmtest_docker_kill_error_1",
+		"dcmtest_docker_rm_error_1",
+		"other_ok_1",
+	}, left)
 }
 
 func TestList(t *testing.T) {
-	out := helperTestOsStdout(t, func() {
-		dcm := NewDcm(NewConfig(), []string{})
-		dcm.Config.Config = yamlConfig{
-			"service": yamlConfig{},
-		}
-		dcm.List()
-	})
-	assert.Equal(t, "service\n", out)
-}
+	var out bytes.Buffer
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = &out
+	dcm.Config.Settings.Groups = map[string][]string{"backend": {"api"}}
+	dcm.Config.Config = yamlConfig{
+		"service": yamlConfig{},
+		"api": yamlConfig{
+			"image":      "username/api",
+			"depends_on": []interface{}{"service"},
+			"labels": yamlConfig{
+				"dcm.repository": "git@github.com:username/api.git",
+				"dcm.groups":     "billing",
+			},
+		},
+	}
+
+	code, err := dcm.List()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "service\napi\n", out.String())
 
-func TestUsage(t *testing.T) {
-	out := helperTestOsStdout(t, func() {
-		dcm := NewDcm(NewConfig(), []string{})
-		dcm.Usage()
-	})
+	out.Reset()
+	code, err = dcm.List("api", "--output", "json")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, `[
+  {
+    "name": "service",
+    "source": "image",
+    "groups": [],
+    "depends_on": []
+  },
+  {
+    "name": "api",
+    "source": "image",
+    "image": "username/api",
+    "repository": "git@github.com:username/api.git",
+    "groups": [
+      "backend",
+      "billing"
+    ],
+    "depends_on": [
+      "service"
+    ]
+  }
+]
+`, out.String())
+
+	out.Reset()
+	code, err = dcm.List("-o", "table", "--except", "api")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SERVICE  SOURCE  IMAGE  REPOSITORY  GROUPS  DEPENDS ON\n"+
+		"service  image   -      -           -       -\n", out.String())
 
-	assert.Contains(t, out, "DCM (Docker-Compose Manager)\n")
-	assert.Contains(t, out, "Usage:\n")
-	assert.Contains(t, out, "Example:\n")
+	code, err = dcm.List("-o", "csv")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Invalid output format [csv], must be one of: text, table, json, yaml")
 }
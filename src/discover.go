@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const originWorkingDir = "working directory"
+
+// dcmrc is the content of the .dcmrc file marking the folder of a project
+type dcmrc struct {
+	Project string `yaml:"project"`
+}
+
+// discover looks for the folder of the project from the given directory
+// up, when neither the folder nor the config file are given. The folder is
+// the first one that either has a .dcmrc file, has the config file of the
+// project, or has the config file of the project the directory is a repo
+// of, as in <dir>/srv/<project>/<service>.
+func (c *Config) discover(wd string) error {
+	if c.Origins["dir"] != originWorkingDir || !c.isDefault("file") {
+		return nil
+	}
+
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		rc := filepath.Join(dir, ".dcmrc")
+		if isFile(rc) {
+			content, err := ioutil.ReadFile(rc)
+			if err != nil {
+				return err
+			}
+			var r dcmrc
+			if err := yaml.Unmarshal(content, &r); err != nil {
+				return fmt.Errorf("Error parsing [%s]: %v", rc, err)
+			}
+			if r.Project != "" && c.isDefault("project") {
+				c.Project = r.Project
+				c.setOrigin("project", rc)
+			}
+			c.Dir = dir
+			c.setOrigin("dir", rc)
+			break
+		}
+
+		if file := filepath.Join(dir, c.Project+".yml"); isFile(file) {
+			c.Dir = dir
+			c.setOrigin("dir", file)
+			break
+		}
+
+		if filepath.Base(filepath.Dir(dir)) == "srv" {
+			project := filepath.Base(dir)
+			root := filepath.Dir(filepath.Dir(dir))
+			file := filepath.Join(root, project+".yml")
+			if isFile(file) && (c.isDefault("project") || c.Project == project) {
+				if c.Project != project {
+					c.Project = project
+					c.setOrigin("project", dir)
+				}
+				c.Dir = root
+				c.setOrigin("dir", file)
+				break
+			}
+		}
+
+		if filepath.Dir(dir) == dir {
+			// Reached the root without finding anything, keep the
+			// working directory
+			break
+		}
+	}
+
+	c.setLocations()
+	return nil
+}
+
+// getCurrentService returns the name of the service whose repo the given
+// directory is in, or an empty string.
+func (c *Config) getCurrentService(wd string) string {
+	srv, wd := resolvePath(c.Srv), resolvePath(wd)
+	rel, err := filepath.Rel(srv, wd)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	name := strings.Split(rel, string(filepath.Separator))[0]
+	if _, ok := c.Config[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// resolvePath resolves the symbolic links of the path when possible, so
+// paths can be compared to the working directory.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dir = resolvePath(dir)
+
+	for _, path := range []string{
+		"/project/srv/blog/api/src",
+		"/project/srv/unknown/api",
+		"/project/docs",
+		"/rc/srv/shop/web",
+		"/rc/docs",
+		"/none",
+	} {
+		require.Nil(t, os.MkdirAll(dir+path, 0777))
+	}
+	for file, content := range map[string]string{
+		"/project/blog.yml": "api:\n  image: api\n",
+		"/project/dcm.yml":  "web:\n  image: web\n",
+		"/rc/.dcmrc":        "project: shop\n",
+		"/rc/shop.yml":      "web:\n  image: web\n",
+	} {
+		require.Nil(t, ioutil.WriteFile(dir+file, []byte(content), 0644))
+	}
+
+	os.Unsetenv("DCM_DIR")
+	os.Unsetenv("DCM_CONFIG_FILE")
+	defer os.Unsetenv("DCM_PROJECT")
+
+	fixtures := []struct {
+		name, wd, envProject string
+		dir, project         string
+		current              string
+	}{
+		{
+			name:    "Project and service inferred from the repo of a service",
+			wd:      "/project/srv/blog/api/src",
+			dir:     "/project",
+			project: "blog",
+			current: "api",
+		},
+		{
+			name:       "Project given by the environment",
+			wd:         "/project/srv/blog/api/src",
+			envProject: "dcm",
+			dir:        "/project",
+			project:    "dcm",
+		},
+		{
+			name:    "Config file of the default project in a parent folder",
+			wd:      "/project/srv/unknown/api",
+			dir:     "/project",
+			project: "dcm",
+		},
+		{
+			name:    "Config file in a parent folder",
+			wd:      "/project/docs",
+			dir:     "/project",
+			project: "dcm",
+		},
+		{
+			name:    "Project given by a .dcmrc file",
+			wd:      "/rc/docs",
+			dir:     "/rc",
+			project: "shop",
+		},
+		{
+			name:    "Current service found in a project with a .dcmrc file",
+			wd:      "/rc/srv/shop/web",
+			dir:     "/rc",
+			project: "shop",
+			current: "web",
+		},
+	}
+
+	for n, test := range fixtures {
+		os.Setenv("DCM_PROJECT", test.envProject)
+
+		c := NewConfig()
+		c.Dir = dir + test.wd
+		c.setLocations()
+		require.Nil(t, c.discover(dir+test.wd))
+		require.Nil(t, c.loadCompose(helperLoadYaml(t, c.File)))
+
+		assert.Equal(t, dir+test.dir, c.Dir, "[%d: %s] Incorrect dir", n, test.name)
+		assert.Equal(t, test.project, c.Project, "[%d: %s] Incorrect project", n, test.name)
+		assert.Equal(t, dir+test.dir+"/"+test.project+".yml", c.File, "[%d: %s] Incorrect file", n, test.name)
+		assert.Equal(t, test.current, c.getCurrentService(dir+test.wd), "[%d: %s] Incorrect current service", n, test.name)
+	}
+
+	// Nothing found, the working directory is kept
+	os.Setenv("DCM_PROJECT", "")
+	c := NewConfig()
+	c.Dir = dir + "/none"
+	require.Nil(t, c.discover(dir+"/none"))
+	assert.Equal(t, dir+"/none", c.Dir)
+	assert.Equal(t, originWorkingDir, c.Origins["dir"])
+
+	// Nothing is discovered when the folder is given
+	c = NewConfig()
+	c.loadOptions(Options{Dir: dir + "/none"})
+	require.Nil(t, c.discover(dir+"/project/docs"))
+	assert.Equal(t, dir+"/none", c.Dir)
+
+	// Negative case: invalid .dcmrc file
+	require.Nil(t, ioutil.WriteFile(dir+"/rc/.dcmrc", []byte("project: [shop]\n"), 0644))
+	c = NewConfig()
+	err = c.discover(dir + "/rc/docs")
+	assert.Contains(t, err.Error(), "Error parsing ["+dir+"/rc/.dcmrc]: ")
+}
+
+func TestLoadFileFromServiceRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dir = resolvePath(dir)
+
+	require.Nil(t, os.MkdirAll(dir+"/srv/blog/api/src", 0777))
+	require.Nil(t, ioutil.WriteFile(dir+"/blog.yml", []byte("api:\n  image: api\n"), 0644))
+
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	defer os.Chdir(wd)
+	require.Nil(t, os.Chdir(dir+"/srv/blog/api/src"))
+
+	os.Unsetenv("DCM_DIR")
+	os.Unsetenv("DCM_PROJECT")
+	os.Unsetenv("DCM_CONFIG_FILE")
+
+	c, err := NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, dir, c.Dir)
+	assert.Equal(t, "blog", c.Project)
+	assert.Equal(t, dir+"/srv/blog", c.Srv)
+	assert.Equal(t, "api", c.CurrentService)
+}
+
+func helperLoadYaml(t *testing.T, file string) yamlConfig {
+	c := &Config{File: file, Files: []string{file}}
+	doc, err := c.loadFiles()
+	require.Nil(t, err)
+	return doc
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultDockerHost is where the docker daemon listens without DOCKER_HOST
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// The time given to the daemon to accept a connection, and then to start
+// answering a request. A pull streams its progress once started, so only
+// the wait for the first line is bounded.
+var (
+	engineDialTimeout     = 10 * time.Second
+	engineResponseTimeout = 60 * time.Second
+)
+
+// Engine is the part of the Docker Engine API DCM uses
+type Engine interface {
+	// ListContainers lists the containers having all the labels, given as
+	// "key=value", including the stopped ones when all is true
+	ListContainers(all bool, labels ...string) ([]EngineContainer, error)
+	// InspectContainer returns the state of a container, its health
+	// included
+	InspectContainer(id string) (EngineContainerState, error)
+	KillContainer(id string) error
+	// RemoveContainer removes a container along with its volumes
+	RemoveContainer(id string) error
+	ListImages() ([]EngineImage, error)
+	RemoveImage(name string) error
+	// PullImage pulls an image with the registry credentials given, if
+	// any, writing the progress to out
+	PullImage(image, auth string, out io.Writer) error
+}
+
+// EngineContainer is a container, as listed by the API
+type EngineContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Ports  []EnginePort      `json:"Ports"`
+}
+
+// EnginePort is a port of a container, published on the host when
+// PublicPort is set
+type EnginePort struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+// EngineContainerState is the state of a container, as inspected through the
+// API. Health is only set for the containers with a healthcheck.
+type EngineContainerState struct {
+	Status    string        `json:"Status"`
+	Running   bool          `json:"Running"`
+	ExitCode  int           `json:"ExitCode"`
+	StartedAt time.Time     `json:"StartedAt"`
+	Health    *EngineHealth `json:"Health"`
+	// WillRestart tells whether the daemon restarts the container once it
+	// exited, as its restart policy says
+	WillRestart bool `json:"-"`
+}
+
+// EngineHealth is the result of the healthcheck of a container: starting,
+// healthy or unhealthy
+type EngineHealth struct {
+	Status string `json:"Status"`
+}
+
+// EngineImage is an image, as listed by the API
+type EngineImage struct {
+	ID       string   `json:"Id"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+// EngineError is an error response of the daemon
+type EngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EngineError) Error() string {
+	return "Error response from daemon: " + e.Message
+}
+
+// isEngineError tells whether the daemon answered with the status code
+func isEngineError(err error, code int) bool {
+	e, ok := err.(*EngineError)
+	return ok && e.StatusCode == code
+}
+
+type engineClient struct {
+	client *http.Client
+	url    string
+}
+
+// NewEngineClient returns a client of the daemon at the given host, in the
+// DOCKER_HOST format: unix:///path/to/docker.sock or tcp://host:port. TCP
+// connections use TLS when DOCKER_TLS_VERIFY is set, with the certificates
+// of DOCKER_CERT_PATH.
+func NewEngineClient(host string) (Engine, error) {
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid docker host [%s]: %v", host, err)
+	}
+
+	dialer := &net.Dialer{Timeout: engineDialTimeout}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   engineDialTimeout,
+		ResponseHeaderTimeout: engineResponseTimeout,
+	}
+	c := &engineClient{client: &http.Client{Transport: transport}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		// The host is not used, but required in the URLs
+		c.url = "http://docker"
+	case "tcp", "http", "https":
+		scheme := "http"
+		if u.Scheme == "https" || os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			config, err := getEngineTLSConfig()
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = config
+			scheme = "https"
+		}
+		c.url = scheme + "://" + u.Host
+	default:
+		return nil, fmt.Errorf("Unsupported docker host [%s], must be either unix:// or tcp://", host)
+	}
+	return c, nil
+}
+
+// getEngineTLSConfig loads the certificates of DOCKER_CERT_PATH, or of the
+// ~/.docker folder
+func getEngineTLSConfig() (*tls.Config, error) {
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading docker certificates from [%s]: %v", dir, err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading docker certificates from [%s]: %v", dir, err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
+// do sends a request to the daemon, and turns the error responses into
+// EngineError. The body of the response is left to close to the caller.
+func (c *engineClient) do(method, path string, query url.Values, header http.Header) (*http.Response, error) {
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the docker daemon: %v", err)
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var message struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &message) != nil || message.Message == "" {
+		message.Message = strings.TrimSpace(string(body))
+	}
+	return nil, &EngineError{StatusCode: resp.StatusCode, Message: message.Message}
+}
+
+// call sends a request, and decodes the JSON response into v unless it is nil
+func (c *engineClient) call(method, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(method, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *engineClient) ListContainers(all bool, labels ...string) ([]EngineContainer, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if len(labels) > 0 {
+		filters, _ := json.Marshal(map[string][]string{"label": labels})
+		query.Set("filters", string(filters))
+	}
+	containers := []EngineContainer{}
+	return containers, c.call("GET", "/containers/json", query, &containers)
+}
+
+func (c *engineClient) InspectContainer(id string) (EngineContainerState, error) {
+	var details struct {
+		State        EngineContainerState `json:"State"`
+		RestartCount int                  `json:"RestartCount"`
+		HostConfig   struct {
+			RestartPolicy struct {
+				Name              string `json:"Name"`
+				MaximumRetryCount int    `json:"MaximumRetryCount"`
+			} `json:"RestartPolicy"`
+		} `json:"HostConfig"`
+	}
+	err := c.call("GET", "/containers/"+url.PathEscape(id)+"/json", nil, &details)
+
+	policy := details.HostConfig.RestartPolicy
+	switch policy.Name {
+	case "always", "unless-stopped":
+		details.State.WillRestart = true
+	case "on-failure":
+		details.State.WillRestart = details.State.ExitCode != 0 &&
+			(policy.MaximumRetryCount == 0 || details.RestartCount < policy.MaximumRetryCount)
+	}
+	return details.State, err
+}
+
+func (c *engineClient) KillContainer(id string) error {
+	return c.call("POST", "/containers/"+url.PathEscape(id)+"/kill", nil, nil)
+}
+
+func (c *engineClient) RemoveContainer(id string) error {
+	return c.call("DELETE", "/containers/"+url.PathEscape(id), url.Values{"v": {"1"}}, nil)
+}
+
+func (c *engineClient) ListImages() ([]EngineImage, error) {
+	images := []EngineImage{}
+	return images, c.call("GET", "/images/json", nil, &images)
+}
+
+func (c *engineClient) RemoveImage(name string) error {
+	return c.call("DELETE", "/images/"+url.PathEscape(name), nil, nil)
+}
+
+func (c *engineClient) PullImage(image, auth string, out io.Writer) error {
+	name, tag := splitImageTag(image)
+	header := http.Header{}
+	if auth != "" {
+		header.Set("X-Registry-Auth", auth)
+	}
+	resp, err := c.do("POST", "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The progress is streamed as JSON messages, the errors included once
+	// the pull started
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Error != "" {
+			return fmt.Errorf("Error pulling image [%s]: %s", image, message.Error)
+		}
+		if message.ID != "" {
+			fmt.Fprintf(out, "%s: %s\n", message.ID, message.Status)
+		} else {
+			fmt.Fprintln(out, message.Status)
+		}
+	}
+}
+
+// splitImageTag splits an image into its name and its tag or digest, which
+// is latest when not given, so the other tags are not pulled as well.
+func splitImageTag(image string) (string, string) {
+	if n := strings.Index(image, "@"); n >= 0 {
+		return image[:n], image[n+1:]
+	}
+	// A colon before the last slash is the port of the registry
+	if n := strings.LastIndex(image, ":"); n > strings.LastIndex(image, "/") {
+		return image[:n], image[n+1:]
+	}
+	return image, "latest"
+}
+
+// getDockerHost returns where the docker CLI would reach the daemon:
+// DOCKER_HOST, or else the docker endpoint of the context named by
+// DOCKER_CONTEXT or the CLI config. It's empty for the default context.
+func getDockerHost() (string, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host, nil
+	}
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		config, err := readDockerConfig()
+		if err != nil {
+			return "", fmt.Errorf("Error reading docker config: %v", err)
+		}
+		name = config.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return "", nil
+	}
+
+	// The CLI stores the contexts in folders named after the digest of
+	// their name
+	digest := sha256.Sum256([]byte(name))
+	file := filepath.Join(getDockerConfigDir(), "contexts", "meta", hex.EncodeToString(digest[:]), "meta.json")
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Error reading docker context [%s]: %v", name, err)
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host string `json:"Host"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return "", fmt.Errorf("Error reading docker context [%s]: %v", name, err)
+	}
+	host := meta.Endpoints["docker"].Host
+	if host == "" {
+		return "", fmt.Errorf("Docker context [%s] has no docker endpoint", name)
+	}
+	return host, nil
+}
+
+// getEngine returns the client of the docker daemon, created once from
+// DOCKER_HOST or the current docker context
+func (d *Dcm) getEngine() (Engine, error) {
+	if d.Engine != nil {
+		return d.Engine, nil
+	}
+	host, err := getDockerHost()
+	if err != nil {
+		return nil, err
+	}
+	engine, err := NewEngineClient(host)
+	if err != nil {
+		return nil, err
+	}
+	d.Engine = engine
+	return engine, nil
+}
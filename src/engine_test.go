@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ========== Fake docker daemon as test helper for the Engine API ==========
+
+type fakeEngine struct {
+	sync.Mutex
+
+	containers []EngineContainer
+	images     []EngineImage
+
+	// errors are the status codes answered instead, by "METHOD /path"
+	errors map[string]int
+
+	// health is the healthcheck status of the containers having one, by ID
+	health map[string]string
+	// exitCodes are the exit codes of the exited containers, 0 if missing
+	exitCodes map[string]int
+	// restartPolicies are the restart policies of the containers having
+	// one, by ID
+	restartPolicies map[string]string
+	// startedAt is when all the containers started
+	startedAt time.Time
+
+	// auths are the X-Registry-Auth headers of the pulls
+	auths []string
+}
+
+// newFakeEngine returns a daemon with the containers and images of the
+// dcmtest project
+func newFakeEngine() *fakeEngine {
+	replica := func(service, index, state string) EngineContainer {
+		return EngineContainer{
+			ID:    "dcmtest_" + service + "_" + index,
+			Names: []string{"/dcmtest_" + service + "_" + index},
+			Labels: map[string]string{
+				composeProjectLabel: "dcmtest",
+				composeServiceLabel: service,
+				composeNumberLabel:  index,
+			},
+			State: state,
+		}
+	}
+	web := replica("ok", "1", "running")
+	web.Ports = []EnginePort{
+		{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+		{IP: "::", PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+		{PrivatePort: 443, Type: "tcp"},
+	}
+	other := replica("ok", "1", "running")
+	other.ID = "other_ok_1"
+	other.Labels = map[string]string{composeProjectLabel: "other", composeServiceLabel: "ok"}
+
+	return &fakeEngine{
+		containers: []EngineContainer{
+			replica("ok", "2", "exited"),
+			web,
+			replica("ok", "3", "running"),
+			replica("ok-worker", "1", "running"),
+			replica("failed_to_run_docker_exec", "1", "running"),
+			replica("docker_kill_error", "1", "running"),
+			replica("docker_rm_error", "1", "running"),
+			other,
+		},
+		images: []EngineImage{
+			{ID: "sha256:1", RepoTags: []string{"dcmtest_ok:latest"}},
+			{ID: "sha256:2", RepoTags: []string{"dcmtest_bad:latest"}},
+			{ID: "sha256:3", RepoTags: []string{"foobar:latest"}},
+		},
+		errors: map[string]int{
+			"POST /containers/dcmtest_docker_kill_error_1/kill": http.StatusInternalServerError,
+			"DELETE /containers/dcmtest_docker_rm_error_1":      http.StatusInternalServerError,
+			"DELETE /images/dcmtest_bad":                        http.StatusConflict,
+		},
+		health:          map[string]string{"dcmtest_ok_1": "healthy", "dcmtest_ok_3": "starting"},
+		exitCodes:       map[string]int{"dcmtest_ok_2": 1},
+		restartPolicies: map[string]string{},
+		startedAt:       time.Now().Add(-2 * time.Hour),
+	}
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if code, ok := f.errors[r.Method+" "+r.URL.Path]; ok {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"message": "fake error for " + r.URL.Path})
+		return
+	}
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "No such object: " + r.URL.Path})
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/containers/json":
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		listed := []EngineContainer{}
+		for _, c := range f.containers {
+			if r.URL.Query().Get("all") == "" && c.State != "running" {
+				continue
+			}
+			matches := true
+			for _, label := range filters["label"] {
+				kv := strings.SplitN(label, "=", 2)
+				if c.Labels[kv[0]] != kv[1] {
+					matches = false
+				}
+			}
+			if matches {
+				listed = append(listed, c)
+			}
+		}
+		json.NewEncoder(w).Encode(listed)
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "containers" && parts[2] == "json":
+		for _, c := range f.containers {
+			if c.ID != parts[1] {
+				continue
+			}
+			state := EngineContainerState{
+				Status:    c.State,
+				Running:   c.State == "running",
+				ExitCode:  f.exitCodes[c.ID],
+				StartedAt: f.startedAt,
+			}
+			if health, ok := f.health[c.ID]; ok {
+				state.Health = &EngineHealth{Status: health}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Id":         c.ID,
+				"State":      state,
+				"HostConfig": map[string]interface{}{"RestartPolicy": map[string]string{"Name": f.restartPolicies[c.ID]}},
+			})
+			return
+		}
+		notFound()
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "containers" && parts[2] == "kill":
+		for i, c := range f.containers {
+			if c.ID != parts[1] {
+				continue
+			}
+			if c.State != "running" {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Container " + c.ID + " is not running"})
+				return
+			}
+			f.containers[i].State = "exited"
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		notFound()
+	case r.Method == "DELETE" && len(parts) == 2 && parts[0] == "containers":
+		for i, c := range f.containers {
+			if c.ID == parts[1] {
+				f.containers = append(f.containers[:i], f.containers[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		notFound()
+	case r.Method == "GET" && r.URL.Path == "/images/json":
+		json.NewEncoder(w).Encode(f.images)
+	case r.Method == "DELETE" && len(parts) == 2 && parts[0] == "images":
+		for i, image := range f.images {
+			for _, tag := range image.RepoTags {
+				if tag == parts[1] || tag == parts[1]+":latest" {
+					f.images = append(f.images[:i], f.images[i+1:]...)
+					json.NewEncoder(w).Encode([]map[string]string{{"Untagged": tag}})
+					return
+				}
+			}
+		}
+		notFound()
+	case r.Method == "POST" && r.URL.Path == "/images/create":
+		f.auths = append(f.auths, r.Header.Get("X-Registry-Auth"))
+		image := r.URL.Query().Get("fromImage")
+		tag := r.URL.Query().Get("tag")
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"status": "Pulling from library/" + image, "id": tag})
+		if image == "missing" {
+			encoder.Encode(map[string]string{"error": "manifest for missing:" + tag + " not found"})
+			return
+		}
+		encoder.Encode(map[string]string{"status": "Status: Downloaded newer image for " + image + ":" + tag})
+		f.images = append(f.images, EngineImage{ID: "sha256:pulled", RepoTags: []string{image + ":" + tag}})
+	default:
+		notFound()
+	}
+}
+
+// helperEngine serves the fake daemon over TCP, and returns a client of it
+func helperEngine(t *testing.T, f *fakeEngine) (Engine, *httptest.Server) {
+	server := httptest.NewServer(f)
+	engine, err := NewEngineClient("tcp://" + server.Listener.Addr().String())
+	require.Nil(t, err)
+	return engine, server
+}
+
+// ========== Here starts the real tests for the Engine API client ==========
+
+func TestNewEngineClient(t *testing.T) {
+	os.Unsetenv("DOCKER_TLS_VERIFY")
+
+	fixtures := []struct {
+		host, url, err string
+	}{
+		{host: "", url: "http://docker"},
+		{host: "unix:///run/user/1000/docker.sock", url: "http://docker"},
+		{host: "tcp://192.168.99.100:2375", url: "http://192.168.99.100:2375"},
+		{host: "npipe:////./pipe/docker_engine", err: "Unsupported docker host [npipe:////./pipe/docker_engine], must be either unix:// or tcp://"},
+		{host: "tcp://[::1", err: `Invalid docker host [tcp://[::1]: parse "tcp://[::1": missing ']' in host`},
+	}
+
+	for n, test := range fixtures {
+		engine, err := NewEngineClient(test.host)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.host)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.host)
+		assert.Equal(t, test.url, engine.(*engineClient).url, "[%d: %s] Incorrect URL", n, test.host)
+	}
+
+	// Negative case: TLS without certificates
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("DOCKER_TLS_VERIFY", "1")
+	os.Setenv("DOCKER_CERT_PATH", dir)
+	defer os.Unsetenv("DOCKER_TLS_VERIFY")
+	defer os.Unsetenv("DOCKER_CERT_PATH")
+	_, err = NewEngineClient("tcp://192.168.99.100:2376")
+	assert.Contains(t, err.Error(), "Error loading docker certificates from ["+dir+"]: ")
+}
+
+func TestEngineClientTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { engineResponseTimeout = timeout }(engineResponseTimeout)
+	engineResponseTimeout = 50 * time.Millisecond
+
+	// Negative case: a daemon that accepts the connection but never answers
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	engine, err := NewEngineClient("tcp://" + server.Listener.Addr().String())
+	require.Nil(t, err)
+	_, err = engine.ListContainers(false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Error connecting to the docker daemon: ")
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+}
+
+func TestEngineClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", dir+"/docker.sock")
+	require.Nil(t, err)
+	server := httptest.NewUnstartedServer(newFakeEngine())
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	engine, err := NewEngineClient("unix://" + dir + "/docker.sock")
+	require.Nil(t, err)
+	containers, err := engine.ListContainers(false, composeServiceLabel+"=ok", composeProjectLabel+"=dcmtest")
+	assert.NoError(t, err)
+	assert.Len(t, containers, 2)
+
+	// Negative case: no daemon
+	engine, err = NewEngineClient("unix://" + dir + "/missing.sock")
+	require.Nil(t, err)
+	_, err = engine.ListImages()
+	assert.Contains(t, err.Error(), "Error connecting to the docker daemon: ")
+}
+
+func TestEngineClient(t *testing.T) {
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	defer server.Close()
+
+	containers, err := engine.ListContainers(true, composeServiceLabel+"=docker_rm_error")
+	assert.NoError(t, err)
+	assert.Equal(t, []EngineContainer{f.containers[6]}, containers)
+
+	state, err := engine.InspectContainer("dcmtest_ok_1")
+	assert.NoError(t, err)
+	assert.True(t, state.Running)
+	assert.Equal(t, &EngineHealth{Status: "healthy"}, state.Health)
+	assert.True(t, f.startedAt.Equal(state.StartedAt))
+	assert.False(t, state.WillRestart)
+	state, err = engine.InspectContainer("dcmtest_ok_2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, state.ExitCode)
+	assert.False(t, state.WillRestart)
+	f.restartPolicies["dcmtest_ok_2"] = "on-failure"
+	state, err = engine.InspectContainer("dcmtest_ok_2")
+	assert.NoError(t, err)
+	assert.True(t, state.WillRestart)
+	_, err = engine.InspectContainer("missing")
+	assert.True(t, isEngineError(err, http.StatusNotFound))
+
+	assert.NoError(t, engine.KillContainer("dcmtest_ok_1"))
+	err = engine.KillContainer("dcmtest_ok_1")
+	assert.EqualError(t, err, "Error response from daemon: Container dcmtest_ok_1 is not running")
+	assert.True(t, isEngineError(err, http.StatusConflict))
+	assert.NoError(t, engine.RemoveContainer("dcmtest_ok_1"))
+	err = engine.RemoveContainer("dcmtest_ok_1")
+	assert.True(t, isEngineError(err, http.StatusNotFound))
+	assert.False(t, isEngineError(err, http.StatusConflict))
+
+	images, err := engine.ListImages()
+	assert.NoError(t, err)
+	assert.Len(t, images, 3)
+	assert.NoError(t, engine.RemoveImage("foobar"))
+	err = engine.RemoveImage("dcmtest_bad")
+	assert.EqualError(t, err, "Error response from daemon: fake error for /images/dcmtest_bad")
+	assert.True(t, isEngineError(err, http.StatusConflict))
+
+	var out bytes.Buffer
+	assert.NoError(t, engine.PullImage("nginx", "auth", &out))
+	assert.Equal(t, "latest: Pulling from library/nginx\nStatus: Downloaded newer image for nginx:latest\n", out.String())
+	assert.Equal(t, []string{"auth"}, f.auths)
+	err = engine.PullImage("missing:1.0", "", &out)
+	assert.EqualError(t, err, "Error pulling image [missing:1.0]: manifest for missing:1.0 not found")
+}
+
+func TestSplitImageTag(t *testing.T) {
+	for n, test := range []struct {
+		image, name, tag string
+	}{
+		{"nginx", "nginx", "latest"},
+		{"nginx:1.25", "nginx", "1.25"},
+		{"registry.local:5000/team/api", "registry.local:5000/team/api", "latest"},
+		{"registry.local:5000/team/api:dev", "registry.local:5000/team/api", "dev"},
+		{"nginx@sha256:abc", "nginx", "sha256:abc"},
+	} {
+		name, tag := splitImageTag(test.image)
+		assert.Equal(t, test.name, name, "[%d: %s] Incorrect name returned", n, test.image)
+		assert.Equal(t, test.tag, tag, "[%d: %s] Incorrect tag returned", n, test.image)
+	}
+}
+
+func TestGetDockerHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	meta := dir + "/contexts/meta/fe9c6bd7a66301f49ca9b6a70b217107cd1284598bfc254700c989b916da791e"
+	require.Nil(t, os.MkdirAll(meta, 0777))
+	require.Nil(t, ioutil.WriteFile(meta+"/meta.json", []byte(`{
+		"Name": "desktop-linux",
+		"Endpoints": {"docker": {"Host": "unix:///home/user/.docker/run/docker.sock"}}
+	}`), 0644))
+
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+	os.Unsetenv("DOCKER_HOST")
+	defer os.Unsetenv("DOCKER_CONTEXT")
+
+	fixtures := []struct {
+		name, host, context, config, expected, err string
+	}{
+		{name: "Positive case: no config file"},
+		{name: "Positive case: default context", config: `{"currentContext": "default"}`},
+		{
+			name:     "Positive case: current context of the config file",
+			config:   `{"currentContext": "desktop-linux"}`,
+			expected: "unix:///home/user/.docker/run/docker.sock",
+		},
+		{
+			name:     "Positive case: DOCKER_CONTEXT overrides the config file",
+			context:  "desktop-linux",
+			config:   `{"currentContext": "default"}`,
+			expected: "unix:///home/user/.docker/run/docker.sock",
+		},
+		{
+			name:     "Positive case: DOCKER_HOST overrides the context",
+			host:     "tcp://192.168.99.100:2375",
+			context:  "desktop-linux",
+			expected: "tcp://192.168.99.100:2375",
+		},
+		{
+			name:    "Negative case: unknown context",
+			context: "missing",
+			err:     "Error reading docker context [missing]: ",
+		},
+		{
+			name:   "Negative case: invalid config file",
+			config: `{"currentContext": `,
+			err:    "Error reading docker config: ",
+		},
+	}
+
+	for n, test := range fixtures {
+		os.Setenv("DOCKER_HOST", test.host)
+		os.Setenv("DOCKER_CONTEXT", test.context)
+		os.Remove(dir + "/config.json")
+		if test.config != "" {
+			require.Nil(t, ioutil.WriteFile(dir+"/config.json", []byte(test.config), 0644))
+		}
+		host, err := getDockerHost()
+		if test.err != "" {
+			require.Error(t, err, "[%d: %s] Nil error returned", n, test.name)
+			assert.Contains(t, err.Error(), test.err, "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.expected, host, "[%d: %s] Incorrect host returned", n, test.name)
+	}
+	os.Unsetenv("DOCKER_HOST")
+}
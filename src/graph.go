@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func getServiceDependencies(configs yamlConfig) []string {
+	deps := []string{}
+
+	// depends_on can be either a list of service names, or a map keyed by
+	// service name when the long syntax with conditions is used
+	switch dependsOn := getMapVal(configs, "depends_on").(type) {
+	case []interface{}:
+		for _, dep := range dependsOn {
+			if dep, ok := dep.(string); ok {
+				deps = append(deps, dep)
+			}
+		}
+	case yamlConfig:
+		for dep := range dependsOn {
+			if dep, ok := dep.(string); ok {
+				deps = append(deps, dep)
+			}
+		}
+	}
+
+	// links are written as either "service" or "service:alias"
+	if links, ok := getMapVal(configs, "links").([]interface{}); ok {
+		for _, link := range links {
+			if link, ok := link.(string); ok {
+				deps = append(deps, strings.SplitN(link, ":", 2)[0])
+			}
+		}
+	}
+
+	sort.Strings(deps)
+
+	// The same service can be listed in both depends_on and links
+	unique := []string{}
+	for i, dep := range deps {
+		if i == 0 || dep != deps[i-1] {
+			unique = append(unique, dep)
+		}
+	}
+	return unique
+}
+
+func getDependencyGraph(services map[string]*Service) map[string][]string {
+	// Dependencies on services that are not part of the config
+	// (e.g. external links) are left out of the graph
+	deps := map[string][]string{}
+	for name, s := range services {
+		for _, dep := range s.DependsOn {
+			if _, ok := services[dep]; !ok || dep == name {
+				continue
+			}
+			deps[name] = append(deps[name], dep)
+		}
+	}
+	return deps
+}
+
+func sortServices(services map[string]*Service) ([]string, error) {
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deps := getDependencyGraph(services)
+	dependents := map[string][]string{}
+	for _, name := range names {
+		for _, dep := range deps[name] {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	// Kahn's algorithm, always picking the alphabetically smallest service
+	// among the ones that are ready, so the order is stable between runs
+	pending := map[string]int{}
+	ready := []string{}
+	for _, service := range names {
+		pending[service] = len(deps[service])
+		if pending[service] == 0 {
+			ready = append(ready, service)
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for len(ready) > 0 {
+		service := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, service)
+		for _, dependent := range dependents[service] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+				sort.Strings(ready)
+			}
+		}
+	}
+
+	if len(sorted) < len(names) {
+		return nil, fmt.Errorf(
+			"Dependency cycle detected between services: %s",
+			strings.Join(findCycle(names, deps, pending), " -> "),
+		)
+	}
+
+	return sorted, nil
+}
+
+func findCycle(services []string, deps map[string][]string, pending map[string]int) []string {
+	// Every service left with pending dependencies is either on a cycle or
+	// depends on one, so walking the unresolved dependencies from any of
+	// them must eventually come back to a service already visited
+	var start string
+	for _, service := range services {
+		if pending[service] > 0 {
+			start = service
+			break
+		}
+	}
+
+	path := []string{}
+	visited := map[string]int{}
+	for service := start; ; {
+		if i, ok := visited[service]; ok {
+			return append(path[i:], service)
+		}
+		visited[service] = len(path)
+		path = append(path, service)
+		for _, dep := range deps[service] {
+			if pending[dep] > 0 {
+				service = dep
+				break
+			}
+		}
+	}
+}
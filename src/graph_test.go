@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetServiceDependencies(t *testing.T) {
+	fixtures := []struct {
+		name   string
+		config yamlConfig
+		deps   []string
+	}{
+		{
+			name:   "No dependencies",
+			config: yamlConfig{"image": "docker-hub-image"},
+			deps:   []string{},
+		},
+		{
+			name: "depends_on as a list",
+			config: yamlConfig{
+				"depends_on": []interface{}{"redis", "db"},
+			},
+			deps: []string{"db", "redis"},
+		},
+		{
+			name: "depends_on as a map with conditions",
+			config: yamlConfig{
+				"depends_on": yamlConfig{
+					"db": yamlConfig{"condition": "service_healthy"},
+				},
+			},
+			deps: []string{"db"},
+		},
+		{
+			name: "links with and without aliases",
+			config: yamlConfig{
+				"depends_on": []interface{}{"redis", "queue"},
+				"links":      []interface{}{"db:database", "queue"},
+			},
+			deps: []string{"db", "queue", "redis"},
+		},
+	}
+
+	for n, test := range fixtures {
+		deps := getServiceDependencies(test.config)
+		assert.Equal(t, test.deps, deps, "[%d: %s] Incorrect dependencies returned", n, test.name)
+	}
+}
+
+func TestSortServices(t *testing.T) {
+	fixtures := []struct {
+		name     string
+		config   yamlConfig
+		services []string
+		err      string
+	}{
+		{
+			name: "Positive case: no dependencies, sorted alphabetically",
+			config: yamlConfig{
+				"web":   yamlConfig{},
+				"api":   yamlConfig{},
+				"cache": yamlConfig{},
+			},
+			services: []string{"api", "cache", "web"},
+		},
+		{
+			name: "Positive case: dependencies come first",
+			config: yamlConfig{
+				"api": yamlConfig{
+					"depends_on": []interface{}{"db"},
+				},
+				"web": yamlConfig{
+					"links": []interface{}{"api:backend"},
+				},
+				"db":     yamlConfig{},
+				"worker": yamlConfig{"depends_on": []interface{}{"db", "queue"}},
+				"queue":  yamlConfig{},
+			},
+			services: []string{"db", "api", "queue", "web", "worker"},
+		},
+		{
+			name: "Positive case: unknown and self dependencies are ignored",
+			config: yamlConfig{
+				"api": yamlConfig{
+					"depends_on": []interface{}{"api", "external"},
+				},
+			},
+			services: []string{"api"},
+		},
+		{
+			name: "Negative case: dependency cycle",
+			config: yamlConfig{
+				"api": yamlConfig{"depends_on": []interface{}{"db"}},
+				"db":  yamlConfig{"depends_on": []interface{}{"worker"}},
+				"web": yamlConfig{"depends_on": []interface{}{"api"}},
+				"worker": yamlConfig{
+					"links": []interface{}{"api"},
+				},
+			},
+			err: "Dependency cycle detected between services: api -> db -> worker -> api",
+		},
+	}
+
+	for n, test := range fixtures {
+		services, err := getServices(test.config, NewSettings())
+		require.Nil(t, err)
+		order, err := sortServices(services)
+		assert.Equal(t, test.services, order, "[%d: %s] Incorrect service order returned", n, test.name)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.name)
+		} else {
+			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Init creates the config file of the project from an existing compose file
+// and from the git checkouts already in the srv folder. The repository and
+// branch of each checkout end up in the dcm labels of the service with the
+// same name.
+func (d *Dcm) Init(args ...string) (int, error) {
+	var from string
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&from, "from", "", "")
+	if err := fs.Parse(args); err != nil {
+		return 1, fmt.Errorf("Error parsing options: %v", err)
+	}
+
+	if from == "" {
+		// The compose file of the project folder is used when there is one
+		if compose := filepath.Join(d.Config.Dir, "docker-compose.yml"); isFile(compose) {
+			from = compose
+		}
+	}
+	doc, err := readComposeFile(from)
+	if err != nil {
+		return 1, err
+	}
+	services := doc
+	if hasServicesSection(doc) {
+		services, _ = doc["services"].(yamlConfig)
+		if services == nil {
+			services = yamlConfig{}
+			doc["services"] = services
+		}
+	}
+
+	checkouts, err := d.getCheckouts()
+	if err != nil {
+		return 1, err
+	}
+	for name, labels := range checkouts {
+		configs, ok := services[name].(yamlConfig)
+		if !ok {
+			configs = yamlConfig{}
+			services[name] = configs
+		}
+		existing, ok := configs["labels"].(yamlConfig)
+		if !ok {
+			existing = yamlConfig{}
+			configs["labels"] = existing
+		}
+		// Labels already in the compose file are kept as they are
+		for label, value := range labels {
+			if existing[label] == nil && value != "" {
+				existing[label] = value
+			}
+		}
+
+		// Services with a repository are built from their checkout
+		// without a build path
+		if configs["image"] == nil && configs["build"] == nil && existing["dcm.repository"] == nil {
+			build, err := filepath.Rel(d.Config.Dir, filepath.Join(d.Config.Srv, name))
+			if err != nil || strings.HasPrefix(build, "..") {
+				build = filepath.Join(d.Config.Srv, name)
+			} else {
+				build = "./" + build
+			}
+			configs["build"] = build
+		}
+	}
+
+	if len(services) == 0 {
+		return 1, fmt.Errorf(
+			"Error initializing project [%s]: no compose file and no git checkouts in [%s]",
+			d.Config.Project, d.Config.Srv,
+		)
+	}
+
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return 1, err
+	}
+	// The file is only created when there is none, even one written
+	// while the project was read
+	file := d.Config.File
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return 1, fmt.Errorf("Config file [%s] already exists", file)
+	}
+	if err != nil {
+		return 1, err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(d.Stdout, "Created config file [%s] with %d service(s).\n", file, len(services))
+
+	return 0, nil
+}
+
+// readComposeFile reads the compose file the config file is created from,
+// or returns an empty version 2 document when there is none.
+func readComposeFile(file string) (yamlConfig, error) {
+	if file == "" {
+		return yamlConfig{"version": "2", "services": yamlConfig{}}, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc := yamlConfig{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("Error parsing compose file [%s]: %s", file, err)
+	}
+	if err := normalizeDoc(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// getCheckouts returns the dcm labels of the git checkouts in the srv folder,
+// indexed by the folder name. Folders that aren't git checkouts are skipped.
+func (d *Dcm) getCheckouts() (map[string]map[string]string, error) {
+	checkouts := map[string]map[string]string{}
+
+	entries, err := ioutil.ReadDir(d.Config.Srv)
+	if os.IsNotExist(err) {
+		return checkouts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(d.Config.Srv, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+			continue
+		}
+
+		// A checkout without remote still gets its branch
+		remote, err := d.Cmd.Exec("git", "config", "--get", "remote.origin.url").Setdir(dir).Out()
+		if err != nil {
+			remote = nil
+		}
+		// Quiet, it fails without output when the HEAD is detached, and
+		// there is no branch to check out
+		branch, err := d.Cmd.Exec("git", "symbolic-ref", "-q", "--short", "HEAD").Setdir(dir).Out()
+		if err != nil && d.Cmd.FormatOutput(branch) != "" {
+			return nil, fmt.Errorf(
+				"Error reading git branch for service [%s]: %v",
+				entry.Name(), d.Cmd.FormatError(err, branch),
+			)
+		}
+		if err != nil {
+			branch = nil
+		}
+		checkouts[entry.Name()] = map[string]string{
+			"dcm.repository": d.Cmd.FormatOutput(remote),
+			"dcm.branch":     d.Cmd.FormatOutput(branch),
+		}
+	}
+
+	return checkouts, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var yamlFixtureInitCompose string = `version: "2"
+services:
+  api:
+    build: ./srv/project/api
+    labels:
+      - dcm.branch=master
+  db:
+    image: mysql
+`
+
+// getInitCheckouts returns the files of a project with the given git
+// checkouts
+func getInitCheckouts(checkouts ...string) map[string]string {
+	files := map[string]string{
+		// Folders that aren't git checkouts are skipped
+		"srv/project/notes/": "",
+	}
+	for _, name := range checkouts {
+		files["srv/project/"+name+"/.git/"] = ""
+	}
+	return files
+}
+
+func TestInit(t *testing.T) {
+	dcm, out, dir := helperCreateTestDcm(t, "project", getInitCheckouts("api", "web", "detached", "no_remote"))
+	defer os.RemoveAll(dir)
+	require.Nil(t, ioutil.WriteFile(dir+"/docker-compose.yml", []byte(yamlFixtureInitCompose), 0644))
+
+	code, err := dcm.Init()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "Created config file ["+dir+"/project.yml] with 5 service(s).\n", out.String())
+
+	content, err := ioutil.ReadFile(dir + "/project.yml")
+	require.Nil(t, err)
+	assert.Equal(t, ""+
+		"services:\n"+
+		"  api:\n"+
+		"    build: ./srv/project/api\n"+
+		"    labels:\n"+
+		"      dcm.branch: master\n"+
+		"      dcm.repository: git@github.com:dcm/api.git\n"+
+		"  db:\n"+
+		"    image: mysql\n"+
+		"  detached:\n"+
+		"    labels:\n"+
+		"      dcm.repository: git@github.com:dcm/detached.git\n"+
+		"  no_remote:\n"+
+		"    build: ./srv/project/no_remote\n"+
+		"    labels:\n"+
+		"      dcm.branch: develop\n"+
+		"  web:\n"+
+		"    labels:\n"+
+		"      dcm.branch: develop\n"+
+		"      dcm.repository: git@github.com:dcm/web.git\n"+
+		"version: \"2\"\n",
+		string(content))
+
+	// Negative case: the config file is never overwritten
+	require.Nil(t, ioutil.WriteFile(dir+"/project.yml", []byte("web:\n  image: nginx\n"), 0644))
+	code, err = dcm.Init()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Config file ["+dir+"/project.yml] already exists")
+	content, err = ioutil.ReadFile(dir + "/project.yml")
+	require.Nil(t, err)
+	assert.Equal(t, "web:\n  image: nginx\n", string(content))
+}
+
+func TestInitFromCheckouts(t *testing.T) {
+	dcm, _, dir := helperCreateTestDcm(t, "project", getInitCheckouts("api"))
+	defer os.RemoveAll(dir)
+
+	code, err := dcm.Init()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+
+	config := helperLoadYaml(t, dir+"/project.yml")
+	assert.Equal(t, yamlConfig{
+		"version": "2",
+		"services": yamlConfig{
+			"api": yamlConfig{
+				// Built from the checkout, without build path
+				"labels": yamlConfig{
+					"dcm.branch":     "develop",
+					"dcm.repository": "git@github.com:dcm/api.git",
+				},
+			},
+		},
+	}, config)
+}
+
+func TestInitErrors(t *testing.T) {
+	fixtures := []struct {
+		name      string
+		checkouts []string
+		args      []string
+		err       string
+	}{
+		{
+			name: "Nothing to create the config file from",
+			err:  "Error initializing project [project]: no compose file and no git checkouts in [%DIR%/srv/project]",
+		},
+		{
+			name: "Missing compose file",
+			args: []string{"--from", "/test/dcm/missing.yml"},
+			err:  "open /test/dcm/missing.yml: no such file or directory",
+		},
+		{
+			name:      "Git error",
+			checkouts: []string{"git_rev_parse_error"},
+			err:       "Error reading git branch for service [git_rev_parse_error]: exit status 128: fatal: not a git repository",
+		},
+		{
+			name: "Unknown option",
+			args: []string{"--force"},
+			err:  "Error parsing options: flag provided but not defined: -force",
+		},
+	}
+
+	for n, test := range fixtures {
+		dcm, _, dir := helperCreateTestDcm(t, "project", getInitCheckouts(test.checkouts...))
+		code, err := dcm.Init(test.args...)
+		assert.Equal(t, 1, code, "[%d: %s] Incorrect exit code returned", n, test.name)
+		assert.EqualError(t, err, strings.Replace(test.err, "%DIR%", dir, -1), "[%d: %s] Incorrect error returned", n, test.name)
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// configView is the resolved config, as printed by `dcm config`
+type configView struct {
+	Dir            string              `yaml:"dir" json:"dir"`
+	Project        string              `yaml:"project" json:"project"`
+	File           string              `yaml:"file" json:"file"`
+	Files          []string            `yaml:"files" json:"files"`
+	Srv            string              `yaml:"srv" json:"srv"`
+	CurrentService string              `yaml:"current_service,omitempty" json:"current_service,omitempty"`
+	Settings       Settings            `yaml:"settings" json:"settings"`
+	Services       map[string]*Service `yaml:"services" json:"services"`
+	Origins        map[string]string   `yaml:"origins,omitempty" json:"origins,omitempty"`
+}
+
+// envReference matches the variables used in a value, e.g. ${VAR:-default}
+var envReference = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+func (d *Dcm) ShowConfig(args ...string) (int, error) {
+	var (
+		output     string
+		showOrigin bool
+	)
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&output, "output", "yaml", "")
+	fs.StringVar(&output, "o", "yaml", "")
+	fs.BoolVar(&showOrigin, "show-origin", false, "")
+
+	// The service can be given either before or after the options
+	if err := fs.Parse(args); err != nil {
+		return 1, fmt.Errorf("Error parsing options: %v", err)
+	}
+	name := fs.Arg(0)
+	if fs.NArg() > 0 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return 1, fmt.Errorf("Error parsing options: %v", err)
+		}
+		if fs.NArg() > 0 {
+			return 1, fmt.Errorf("Error: only one service name expected, got [%s].", strings.Join(fs.Args(), " "))
+		}
+	}
+
+	services, err := d.Config.Services()
+	if err != nil {
+		return 1, err
+	}
+	if name != "" {
+		s, ok := services[name]
+		if !ok {
+			return 1, errors.New("Service not exists.")
+		}
+		services = map[string]*Service{name: s}
+	}
+
+	view := configView{
+		Dir:            d.Config.Dir,
+		Project:        d.Config.Project,
+		File:           d.Config.File,
+		Files:          d.Config.Files,
+		Srv:            d.Config.Srv,
+		CurrentService: d.Config.CurrentService,
+		Settings:       d.Config.Settings,
+		Services:       services,
+	}
+	if showOrigin {
+		view.Origins = d.Config.getOrigins(services)
+	}
+
+	if err := writeOutput(d.Stdout, output, view); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// getOrigins tells where each value of the resolved config comes from: a
+// command line option, an environment variable, a line of a config file,
+// or a default value. Values read from a line using variables also list
+// the variables.
+func (c *Config) getOrigins(services map[string]*Service) map[string]string {
+	origins := map[string]string{}
+	for name, origin := range c.Origins {
+		origins[name] = origin
+	}
+
+	positions := c.getPositions()
+	locate := func(service string, keys ...string) string {
+		pos := positions.Locate(service, keys...)
+		if pos.Line == 0 {
+			// The key exists but its line can't be told, e.g. a label
+			// given in the list form
+			return pos.File
+		}
+		origin := fmt.Sprintf("%s:%d", pos.File, pos.Line)
+
+		vars := []string{}
+		text := strings.Replace(positions.Text(pos), "$$", "", -1)
+		for _, match := range envReference.FindAllStringSubmatch(text, -1) {
+			vars = append(vars, match[1])
+		}
+		if len(vars) > 0 {
+			origin += " (" + strings.Join(vars, ", ") + ")"
+		}
+		return origin
+	}
+
+	settings, _ := c.getSettingsBlock()
+	for _, key := range []string{"default_branch", "init_shell", "srv", "compose_binary", "jobs", "port_offset", "required_version", "wait_timeout", "groups"} {
+		origin := originDefault
+		if _, ok := settings[key]; ok {
+			origin = locate("x-dcm", key)
+		}
+		origins["settings."+key] = origin
+	}
+
+	for name, s := range services {
+		labels, _ := getMapVal(s.Config, "labels").(yamlConfig)
+		for label := range dcmLabels {
+			key := "services." + name + "." + strings.TrimPrefix(label, "dcm.")
+			switch {
+			case labels[label] != nil:
+				origins[key] = locate(name, "labels", label)
+			case label == "dcm.initscript_shell":
+				origins[key] = origins["settings.init_shell"]
+			case label == "dcm.updateable" || label == "dcm.initscript_target":
+				origins[key] = originDefault
+			}
+		}
+		for option := range s.Config {
+			option := fmt.Sprint(option)
+			origins["services."+name+".config."+option] = locate(name, option)
+		}
+	}
+
+	return origins
+}
+
+// getSettingsBlock returns the x-dcm block of the merged config files
+func (c *Config) getSettingsBlock() (yamlConfig, error) {
+	doc, err := c.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	settings, _ := doc["x-dcm"].(yamlConfig)
+	return settings, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var yamlFixtureInspect string = `version: "2"
+x-dcm:
+  default_branch: develop
+services:
+  api:
+    image: api
+    ports:
+      - "8080:80"
+    labels:
+      dcm.branch: ${API_BRANCH:-master}
+`
+
+// fixtureInspectFiles are the config file of the project and its local
+// override file
+var fixtureInspectFiles = map[string]string{
+	"project.yml":       yamlFixtureInspect,
+	"project.local.yml": "services:\n  api:\n    image: api:local\n",
+}
+
+func TestShowConfig(t *testing.T) {
+	dcm, out, dir := helperCreateTestDcm(t, "project", fixtureInspectFiles)
+	defer os.RemoveAll(dir)
+	require.Nil(t, dcm.Config.loadFile())
+
+	code, err := dcm.ShowConfig()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"dir: "+dir+"\n"+
+		"project: project\n"+
+		"file: "+dir+"/project.yml\n"+
+		"files:\n"+
+		"- "+dir+"/project.yml\n"+
+		"- "+dir+"/project.local.yml\n"+
+		"srv: "+dir+"/srv/project\n"+
+		"settings:\n"+
+		"  default_branch: develop\n"+
+		"  init_shell: /bin/bash\n"+
+		"  compose_binary: auto\n"+
+		"  jobs: 1\n"+
+		"  wait_timeout: 120\n"+
+		"services:\n"+
+		"  api:\n"+
+		"    image: api:local\n"+
+		"    branch: master\n"+
+		"    initscript_shell: /bin/bash\n"+
+		"    initscript_target: host\n"+
+		"    updateable: true\n"+
+		"    depends_on: []\n"+
+		"    config:\n"+
+		"      image: api:local\n"+
+		"      labels:\n"+
+		"        dcm.branch: master\n"+
+		"      ports:\n"+
+		"      - 8080:80\n",
+		out.String())
+
+	out.Reset()
+	code, err = dcm.ShowConfig("-o", "json", "api")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "\"project\": \"project\",\n")
+	assert.Contains(t, out.String(), "\"labels\": {\n          \"dcm.branch\": \"master\"\n        }")
+
+	// The options can also follow the service name
+	out.Reset()
+	code, err = dcm.ShowConfig("api", "-o", "json")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "\"project\": \"project\",\n")
+
+	// Negative cases
+	fixtures := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"missing"}, "Service not exists."},
+		{[]string{"--output", "xml"}, "Invalid output format [xml], must be one of: yaml, json"},
+		{[]string{"--unknown"}, "Error parsing options: flag provided but not defined: -unknown"},
+		{[]string{"api", "--unknown"}, "Error parsing options: flag provided but not defined: -unknown"},
+		{[]string{"api", "db"}, "Error: only one service name expected, got [db]."},
+		{[]string{"api", "-o", "json", "db"}, "Error: only one service name expected, got [db]."},
+	}
+	for n, test := range fixtures {
+		code, err := dcm.ShowConfig(test.args...)
+		assert.Equal(t, 1, code, "[%d: %v] Incorrect exit code returned", n, test.args)
+		assert.EqualError(t, err, test.err, "[%d: %v] Incorrect error returned", n, test.args)
+	}
+}
+
+func TestGetOrigins(t *testing.T) {
+	dcm, _, dir := helperCreateTestDcm(t, "project", fixtureInspectFiles)
+	defer os.RemoveAll(dir)
+	require.Nil(t, dcm.Config.loadFile())
+
+	services, err := dcm.Config.Services()
+	require.Nil(t, err)
+
+	origins := dcm.Config.getOrigins(services)
+	expected := map[string]string{
+		"dir":                           "--dir",
+		"project":                       "--project",
+		"file":                          "default",
+		"srv":                           "default",
+		"settings.default_branch":       dir + "/project.yml:3",
+		"settings.jobs":                 "default",
+		"settings.wait_timeout":         "default",
+		"services.api.branch":           dir + "/project.yml:10 (API_BRANCH)",
+		"services.api.initscript_shell": "default",
+		"services.api.updateable":       "default",
+		"services.api.config.image":     dir + "/project.local.yml:3",
+		"services.api.config.ports":     dir + "/project.yml:7",
+		"services.api.config.labels":    dir + "/project.yml:9",
+	}
+	for key, origin := range expected {
+		assert.Equal(t, origin, origins[key], "[%s] Incorrect origin returned", key)
+	}
+	_, ok := origins["services.api.repository"]
+	assert.False(t, ok, "Origin returned for a value that is not set")
+}
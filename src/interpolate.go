@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// getEnv returns the variables available for interpolation: the ones from
+// the .env file next to the config file, overridden by the ones from the
+// process environment, and the DCM built-ins.
+func (c *Config) getEnv() (map[string]string, error) {
+	env, err := getDotEnv(filepath.Join(filepath.Dir(c.File), ".env"))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range os.Environ() {
+		parts := strings.SplitN(item, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	for name, value := range c.getBuiltinEnv() {
+		env[name] = value
+	}
+	return env, nil
+}
+
+// getBuiltinEnv returns the variables DCM exposes to the config files and
+// to docker-compose.
+func (c *Config) getBuiltinEnv() map[string]string {
+	return map[string]string{
+		"DCM_DIR":     c.Dir,
+		"DCM_PROJECT": c.Project,
+		"DCM_SRV":     c.Srv,
+	}
+}
+
+// getDotEnv reads a file of "KEY=value" lines. Blank lines and comments are
+// skipped, and a missing file is the same as an empty one.
+func getDotEnv(file string) (map[string]string, error) {
+	env := map[string]string{}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		parts := strings.SplitN(text, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !isEnvName(name) {
+			return nil, fmt.Errorf("Error reading env file [%s]: invalid line %d", file, line)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[name] = value
+	}
+
+	return env, scanner.Err()
+}
+
+// interpolateConfig replaces the variables in all the string values of the
+// config. Keys are left as they are.
+func interpolateConfig(config interface{}, env map[string]string, path string) (interface{}, error) {
+	switch config := config.(type) {
+	case string:
+		value, err := interpolate(config, env)
+		if err != nil {
+			return nil, fmt.Errorf("Error interpolating [%s]: %v", path, err)
+		}
+		return value, nil
+	case yamlConfig:
+		// Sorted so the first error found is always the same
+		keys := []interface{}{}
+		for key := range config {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+
+		interpolated := yamlConfig{}
+		for _, key := range keys {
+			name := fmt.Sprint(key)
+			if path != "" {
+				name = path + "." + name
+			}
+			value, err := interpolateConfig(config[key], env, name)
+			if err != nil {
+				return nil, err
+			}
+			interpolated[key] = value
+		}
+		return interpolated, nil
+	case []interface{}:
+		interpolated := make([]interface{}, len(config))
+		for i, value := range config {
+			value, err := interpolateConfig(value, env, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			interpolated[i] = value
+		}
+		return interpolated, nil
+	}
+	return config, nil
+}
+
+// interpolate replaces the variables in a string the same way compose does:
+//
+//	$VAR or ${VAR}  value of VAR, empty when it's not set
+//	${VAR:-value}   value when VAR is not set or empty
+//	${VAR-value}    value when VAR is not set
+//	${VAR:?error}   fails with the error when VAR is not set or empty
+//	${VAR?error}    fails with the error when VAR is not set
+//	${VAR:+value}   value when VAR is set and not empty, empty otherwise
+//	${VAR+value}    value when VAR is set, empty otherwise
+//	$$              a literal $
+func interpolate(value string, env map[string]string) (string, error) {
+	var result strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		rest := value[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			result.WriteByte('$')
+			i++
+		case strings.HasPrefix(rest, "{"):
+			end := getClosingBrace(rest)
+			if end < 0 {
+				return "", fmt.Errorf("Invalid interpolation format in [%s]", value)
+			}
+			expanded, err := expandVariable(rest[1:end], env)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(expanded)
+			i += end + 1
+		default:
+			n := 0
+			for n < len(rest) && isEnvNameChar(rest[n], n == 0) {
+				n++
+			}
+			if n == 0 {
+				return "", fmt.Errorf("Invalid interpolation format in [%s]", value)
+			}
+			result.WriteString(env[rest[:n]])
+			i += n
+		}
+	}
+
+	return result.String(), nil
+}
+
+// expandVariable expands the expression between the braces of ${...}
+func expandVariable(expr string, env map[string]string) (string, error) {
+	n := 0
+	for n < len(expr) && isEnvNameChar(expr[n], n == 0) {
+		n++
+	}
+	name, modifier := expr[:n], expr[n:]
+	if name == "" {
+		return "", fmt.Errorf("Invalid interpolation format in [${%s}]", expr)
+	}
+
+	value, set := env[name]
+	if modifier == "" {
+		return value, nil
+	}
+
+	// The colon forms also treat an empty value as not set
+	if strings.HasPrefix(modifier, ":") {
+		modifier = modifier[1:]
+		set = set && value != ""
+	}
+	if modifier == "" {
+		return "", fmt.Errorf("Invalid interpolation format in [${%s}]", expr)
+	}
+
+	// The default values and error messages may use variables too
+	word, err := interpolate(modifier[1:], env)
+	if err != nil {
+		return "", err
+	}
+
+	switch modifier[0] {
+	case '-':
+		if set {
+			return value, nil
+		}
+		return word, nil
+	case '?':
+		if set {
+			return value, nil
+		}
+		return "", fmt.Errorf("Required variable [%s] is missing a value: %s", name, word)
+	case '+':
+		if set {
+			return word, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("Invalid interpolation format in [${%s}]", expr)
+}
+
+// getClosingBrace returns the index of the brace closing the one the string
+// starts with, or -1 when there is none.
+func getClosingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{
+		"BRANCH": "develop",
+		"EMPTY":  "",
+		"DIR":    "/srv",
+	}
+
+	fixtures := []struct {
+		value, expected, err string
+	}{
+		{"no variables", "no variables", ""},
+		{"${BRANCH}", "develop", ""},
+		{"$BRANCH-1", "develop-1", ""},
+		{"${DIR}/api:/app", "/srv/api:/app", ""},
+		{"${MISSING}", "", ""},
+		{"${MISSING:-master}", "master", ""},
+		{"${EMPTY:-master}", "master", ""},
+		{"${EMPTY-master}", "", ""},
+		{"${BRANCH:-master}", "develop", ""},
+		{"${MISSING:-${DIR}/default}", "/srv/default", ""},
+		{"${BRANCH:?not set}", "develop", ""},
+		{"${EMPTY?not set}", "", ""},
+		{"${EMPTY:+set}", "", ""},
+		{"${BRANCH+set}", "set", ""},
+		{"$$BRANCH costs $$5", "$BRANCH costs $5", ""},
+		{"${MISSING:?please set it}", "", "Required variable [MISSING] is missing a value: please set it"},
+		{"${EMPTY:?}", "", "Required variable [EMPTY] is missing a value: "},
+		{"${BRANCH", "", "Invalid interpolation format in [${BRANCH]"},
+		{"${}", "", "Invalid interpolation format in [${}]"},
+		{"${BRANCH:}", "", "Invalid interpolation format in [${BRANCH:}]"},
+		{"${BRANCH/a/b}", "", "Invalid interpolation format in [${BRANCH/a/b}]"},
+		{"cost: 5$", "", "Invalid interpolation format in [cost: 5$]"},
+	}
+
+	for n, test := range fixtures {
+		value, err := interpolate(test.value, env)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.value)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.value)
+		assert.Equal(t, test.expected, value, "[%d: %s] Incorrect value returned", n, test.value)
+	}
+}
+
+func TestInterpolateConfig(t *testing.T) {
+	env := map[string]string{"BRANCH": "develop"}
+
+	config, err := interpolateConfig(yamlConfig{
+		"api": yamlConfig{
+			"ports":  []interface{}{"${PORT:-80}:80", 8080},
+			"labels": yamlConfig{"dcm.branch": "${BRANCH}", "${KEY}": "kept"},
+		},
+	}, env, "")
+	assert.NoError(t, err)
+	assert.Equal(t, yamlConfig{
+		"api": yamlConfig{
+			"ports":  []interface{}{"80:80", 8080},
+			"labels": yamlConfig{"dcm.branch": "develop", "${KEY}": "kept"},
+		},
+	}, config)
+
+	_, err = interpolateConfig(yamlConfig{
+		"api": yamlConfig{"ports": []interface{}{"${PORT:?port needed}:80"}},
+	}, env, "services")
+	assert.EqualError(t, err, "Error interpolating [services.api.ports[0]]: Required variable [PORT] is missing a value: port needed")
+}
+
+func TestGetDotEnv(t *testing.T) {
+	env, err := getDotEnv("/test/dcm/missing/.env")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, env)
+
+	file := helperCreateTestFile(t, "dotenv", ""+
+		"# Comment\n"+
+		"\n"+
+		"BRANCH=develop\n"+
+		"export PORT = 8080\n"+
+		"NAME=\"My project\"\n"+
+		"QUOTE='it''s'\n"+
+		"EMPTY=\n")
+	defer os.Remove(file)
+
+	env, err = getDotEnv(file)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"BRANCH": "develop",
+		"PORT":   "8080",
+		"NAME":   "My project",
+		"QUOTE":  "it''s",
+		"EMPTY":  "",
+	}, env)
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("BRANCH=develop\nnot a variable\n"), 0644))
+	_, err = getDotEnv(file)
+	assert.EqualError(t, err, "Error reading env file ["+file+"]: invalid line 2")
+}
+
+var yamlFixtureInterpolation string = `
+version: "2"
+x-dcm:
+  srv: ${CHECKOUTS:-./srv}
+services:
+  api:
+    build: ${DCM_SRV}/api
+    labels:
+      dcm.repository: git@github.com:${GIT_USER}/api.git
+      dcm.branch: ${FEATURE_BRANCH:-develop}
+      dcm.initscript: $${HOME}/init.bash
+`
+
+func TestCreateNewConfigFileWithInterpolation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := dir + "/project.yml"
+	require.Nil(t, ioutil.WriteFile(file, []byte(yamlFixtureInterpolation), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/.env", []byte("GIT_USER=dotenv\nCHECKOUTS=./checkouts\n"), 0644))
+	os.Setenv("DCM_DIR", dir)
+	defer os.Unsetenv("DCM_DIR")
+	os.Setenv("DCM_CONFIG_FILE", file)
+	os.Setenv("GIT_USER", "username")
+	defer os.Unsetenv("GIT_USER")
+
+	// The process environment takes precedence over the .env file
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, dir+"/checkouts", config.Srv)
+	s, err := config.Service("api")
+	require.Nil(t, err)
+	assert.Equal(t, dir+"/checkouts/api", s.Build)
+	assert.Equal(t, "git@github.com:username/api.git", s.Repository)
+	assert.Equal(t, "develop", s.Branch)
+	assert.Equal(t, "${HOME}/init.bash", s.InitScript)
+
+	os.Setenv("FEATURE_BRANCH", "feature-x")
+	defer os.Unsetenv("FEATURE_BRANCH")
+	config, err = NewConfigFile()
+	require.Nil(t, err)
+	s, err = config.Service("api")
+	require.Nil(t, err)
+	assert.Equal(t, "feature-x", s.Branch)
+
+	// Negative case: invalid .env file
+	require.Nil(t, ioutil.WriteFile(dir+"/.env", []byte("invalid"), 0644))
+	_, err = NewConfigFile()
+	assert.EqualError(t, err, "Error reading env file ["+dir+"/.env]: invalid line 1")
+}
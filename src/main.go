@@ -13,12 +13,10 @@ func main() {
 }
 
 func execDcmCmd() (int, error) {
-	conf, err := NewConfigFile()
-	if err != nil {
-		return 1, err
-	}
+	// The config file is loaded once the command line options telling
+	// where it is are parsed
 	args := os.Args[1:]
-	dcm := NewDcm(conf, args)
+	dcm := NewDcm(NewConfig(), args)
 	code, err := dcm.Command()
 	if err != nil {
 		return code, err
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Output formats of the read-only commands. Text is the free-form output
+// the commands print by default, meant for humans only.
+const (
+	outputText  = "text"
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// checkOutputFormat fails unless the format is one of the ones the command
+// supports
+func checkOutputFormat(format string, formats ...string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid output format [%s], must be one of: %s", format, strings.Join(formats, ", "))
+}
+
+// writeOutput writes the value in the given format, either yaml or json
+func writeOutput(w io.Writer, format string, v interface{}) error {
+	var (
+		out []byte
+		err error
+	)
+	switch format {
+	case outputYAML:
+		out, err = yaml.Marshal(v)
+	case outputJSON:
+		out, err = json.MarshalIndent(v, "", "  ")
+		out = append(out, '\n')
+	default:
+		return checkOutputFormat(format, outputYAML, outputJSON)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// writeTable writes the rows in aligned columns under the header, with a
+// dash in the empty cells
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if cell == "" {
+				cell = "-"
+			}
+			cells[i] = cell
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// parseOutputOption takes the --output (or -o) option out of the args of a
+// command, given before or after the other ones, and returns its value, or
+// def when it's not given.
+func parseOutputOption(args []string, def string) (string, []string, error) {
+	output := def
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--output" || arg == "-o":
+			if i+1 == len(args) {
+				return "", nil, fmt.Errorf("Error parsing options: flag needs an argument: %s", arg)
+			}
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "-o="):
+			output = arg[strings.Index(arg, "=")+1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return output, rest, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOutputFormat(t *testing.T) {
+	assert.NoError(t, checkOutputFormat("json", outputTable, outputJSON))
+	assert.EqualError(t, checkOutputFormat("text", outputTable, outputJSON), "Invalid output format [text], must be one of: table, json")
+}
+
+func TestWriteOutput(t *testing.T) {
+	var out bytes.Buffer
+	v := map[string][]string{"api": {"db"}}
+
+	assert.NoError(t, writeOutput(&out, outputJSON, v))
+	assert.Equal(t, "{\n  \"api\": [\n    \"db\"\n  ]\n}\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, writeOutput(&out, outputYAML, v))
+	assert.Equal(t, "api:\n- db\n", out.String())
+
+	assert.EqualError(t, writeOutput(&out, "xml", v), "Invalid output format [xml], must be one of: yaml, json")
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	err := writeTable(&out, []string{"SERVICE", "IMAGE", "PORTS"}, [][]string{
+		{"api", "", "8080"},
+		{"worker-long-name", "username/worker", ""},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SERVICE           IMAGE            PORTS\n"+
+		"api               -                8080\n"+
+		"worker-long-name  username/worker  -\n", out.String())
+}
+
+func TestParseOutputOption(t *testing.T) {
+	for n, test := range []struct {
+		args   []string
+		output string
+		rest   []string
+	}{
+		{[]string{}, "table", []string{}},
+		{[]string{"-o", "json", "api"}, "json", []string{"api"}},
+		{[]string{"api", "--output=yaml", "--group", "billing"}, "yaml", []string{"api", "--group", "billing"}},
+		{[]string{"-o=json"}, "json", []string{}},
+	} {
+		output, rest, err := parseOutputOption(test.args, "table")
+		assert.NoError(t, err, "[%d: %v] Non-nil error returned", n, test.args)
+		assert.Equal(t, test.output, output, "[%d: %v] Incorrect output returned", n, test.args)
+		assert.Equal(t, test.rest, rest, "[%d: %v] Incorrect args returned", n, test.args)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	servicePending = iota
+	serviceRunning
+	serviceDone
+	serviceFailed
+	serviceSkipped
+)
+
+type serviceResult struct {
+	service string
+	code    int
+	err     error
+	out     *bytes.Buffer
+}
+
+type serviceError struct {
+	Service string
+	Err     error
+}
+
+type serviceErrors []serviceError
+
+func (e serviceErrors) Error() string {
+	lines := []string{fmt.Sprintf("%d service(s) failed:", len(e))}
+	for _, se := range e {
+		lines = append(lines, fmt.Sprintf("  [%s] %v", se.Service, se.Err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fork returns a copy of Dcm that writes all its output, including the
+// output of the commands it executes, to the given writer.
+func (d *Dcm) fork(out io.Writer) *Dcm {
+	f := *d
+	f.Stdout = out
+	f.Cmd = d.Cmd.Clone().SetStdin(nil).SetStdout(out).SetStderr(out)
+	return &f
+}
+
+func (d *Dcm) runServices(services map[string]*Service, order []string, fn doForService) (int, error) {
+	jobs := d.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	deps := getDependencyGraph(services)
+	state := map[string]int{}
+	results := make(chan serviceResult)
+	running := 0
+	stopped := false
+
+	var (
+		failedCode int
+		failedErr  error
+		failures   serviceErrors
+	)
+
+	handle := func(r serviceResult) {
+		var out io.Writer = d.Stdout
+		if r.out != nil {
+			out = r.out
+		}
+		if r.err != nil && r.code == 0 {
+			// Errors returned with a zero code are only reported
+			fmt.Fprintln(out, r.err)
+		}
+		if r.out != nil {
+			// Flush the buffered output of the service in one go, so
+			// it doesn't get interleaved with the other services
+			d.Stdout.Write(r.out.Bytes())
+		}
+		if r.err == nil || r.code == 0 {
+			state[r.service] = serviceDone
+			return
+		}
+
+		// Only when error code is not zero and error is not nil
+		// then the service is considered failed
+		state[r.service] = serviceFailed
+		if d.KeepGoing {
+			failures = append(failures, serviceError{r.service, r.err})
+		} else if failedErr == nil {
+			failedCode, failedErr = r.code, r.err
+			stopped = true
+		}
+	}
+
+	for {
+		for _, service := range order {
+			if stopped || running >= jobs {
+				break
+			}
+			if state[service] != servicePending {
+				continue
+			}
+
+			ready := true
+			for _, dep := range deps[service] {
+				if state[dep] == serviceDone {
+					continue
+				}
+				if state[dep] == serviceFailed || state[dep] == serviceSkipped {
+					state[service] = serviceSkipped
+					failures = append(failures, serviceError{
+						service,
+						fmt.Errorf("Skipped, depends on failed service [%s]", dep),
+					})
+				}
+				ready = false
+				break
+			}
+			if !ready {
+				continue
+			}
+
+			state[service] = serviceRunning
+			if jobs == 1 {
+				// Run serially in the foreground, without buffering the output
+				code, err := fn(d, services[service])
+				handle(serviceResult{service, code, err, nil})
+				continue
+			}
+
+			running++
+			go func(service string) {
+				out := &bytes.Buffer{}
+				code, err := fn(d.fork(out), services[service])
+				results <- serviceResult{service, code, err, out}
+			}(service)
+		}
+
+		if running == 0 {
+			break
+		}
+		handle(<-results)
+		running--
+	}
+
+	if failedErr != nil {
+		return failedCode, failedErr
+	}
+	if len(failures) > 0 {
+		// Report the failures in the same order the services are visited,
+		// regardless of the order in which they finished
+		index := map[string]int{}
+		for i, service := range order {
+			index[service] = i
+		}
+		sort.SliceStable(failures, func(i, j int) bool {
+			return index[failures[i].Service] < index[failures[j].Service]
+		})
+		return 1, failures
+	}
+	return 0, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceErrors(t *testing.T) {
+	err := serviceErrors{
+		{"api", errors.New("exit status 1")},
+		{"web", errors.New("Skipped, depends on failed service [api]")},
+	}
+
+	assert.EqualError(t, err, "2 service(s) failed:\n"+
+		"  [api] exit status 1\n"+
+		"  [web] Skipped, depends on failed service [api]")
+}
+
+func TestFork(t *testing.T) {
+	var out bytes.Buffer
+
+	dcm := NewDcm(NewConfig(), []string{})
+	f := dcm.fork(&out)
+
+	assert.Equal(t, &out, f.Stdout)
+	assert.Equal(t, &Cmd{stdout: &out, stderr: &out}, f.Cmd)
+	assert.Equal(t, dcm.Config, f.Config)
+	assert.NotEqual(t, dcm.Stdout, f.Stdout)
+}
+
+func TestRunServicesInParallel(t *testing.T) {
+	var (
+		out  bytes.Buffer
+		mu   sync.Mutex
+		done []string
+	)
+
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Cmd = &CmdMock{}
+	dcm.Stdout = &out
+	dcm.Jobs = 3
+	dcm.Config.Config = yamlConfig{
+		"api":    yamlConfig{"depends_on": []interface{}{"db"}},
+		"db":     yamlConfig{},
+		"web":    yamlConfig{"depends_on": []interface{}{"api"}},
+		"worker": yamlConfig{},
+		"cache":  yamlConfig{},
+	}
+
+	code, err := dcm.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		// Every dependency must be finished before the service starts
+		mu.Lock()
+		for _, dep := range s.DependsOn {
+			assert.Contains(t, done, dep, "[%s] Started before its dependency", s.Name)
+		}
+		mu.Unlock()
+
+		fmt.Fprintln(d.Stdout, s.Name, "start")
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintln(d.Stdout, s.Name, "end")
+
+		mu.Lock()
+		done = append(done, s.Name)
+		mu.Unlock()
+		return 0, nil
+	})
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Len(t, done, 5)
+
+	// The output of each service is flushed in one go
+	for _, service := range []string{"api", "cache", "db", "web", "worker"} {
+		assert.Contains(t, out.String(), service+" start\n"+service+" end\n")
+	}
+}
+
+func TestRunServicesFailures(t *testing.T) {
+	config := yamlConfig{
+		"api":    yamlConfig{"depends_on": []interface{}{"db"}},
+		"db":     yamlConfig{},
+		"web":    yamlConfig{"depends_on": []interface{}{"api"}},
+		"worker": yamlConfig{},
+	}
+	fn := func(d *Dcm, s *Service) (int, error) {
+		switch s.Name {
+		case "db":
+			return 2, errors.New("db failed")
+		case "worker":
+			return 0, errors.New("worker warning")
+		}
+		return 0, nil
+	}
+
+	fixtures := []struct {
+		name      string
+		jobs      int
+		keepGoing bool
+		code      int
+		err       string
+	}{
+		{
+			name: "Fail fast when running serially",
+			jobs: 1,
+			code: 2,
+			err:  "db failed",
+		},
+		{
+			name: "Fail fast when running in parallel",
+			jobs: 4,
+			code: 2,
+			err:  "db failed",
+		},
+		{
+			name:      "Keep going when running serially",
+			jobs:      1,
+			keepGoing: true,
+			code:      1,
+			err: "3 service(s) failed:\n" +
+				"  [db] db failed\n" +
+				"  [api] Skipped, depends on failed service [db]\n" +
+				"  [web] Skipped, depends on failed service [api]",
+		},
+		{
+			name:      "Keep going when running in parallel",
+			jobs:      4,
+			keepGoing: true,
+			code:      1,
+			err: "3 service(s) failed:\n" +
+				"  [db] db failed\n" +
+				"  [api] Skipped, depends on failed service [db]\n" +
+				"  [web] Skipped, depends on failed service [api]",
+		},
+	}
+
+	for n, test := range fixtures {
+		var out bytes.Buffer
+
+		dcm := NewDcm(NewConfig(), []string{})
+		dcm.Cmd = &CmdMock{}
+		dcm.Stdout = &out
+		dcm.Jobs = test.jobs
+		dcm.KeepGoing = test.keepGoing
+		dcm.Config.Config = config
+
+		code, err := dcm.doForEachService(fn)
+		assert.Equal(t, test.code, code, "[%d: %s] Incorrect error code returned", n, test.name)
+		assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.name)
+		if test.keepGoing {
+			assert.Contains(t, out.String(), "worker warning\n", "[%d: %s] Warning not printed", n, test.name)
+		}
+	}
+}
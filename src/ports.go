@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// autoPortOffset marks the port offset derived from the project name
+const autoPortOffset = -1
+
+// getAutoPortOffset derives the port offset of a project from the number its
+// name ends with, e.g. 200 for instance2, and 0 without number.
+func getAutoPortOffset(project string) int {
+	digits := regexp.MustCompile(`[0-9]+$`).FindString(project)
+	n, _ := strconv.Atoi(digits)
+	return n * 100
+}
+
+// hostPort is a port of the host a service publishes a container port on
+type hostPort struct {
+	Service, IP, Protocol string
+	Port                  int
+}
+
+func (p hostPort) String() string {
+	addr := strconv.Itoa(p.Port)
+	if p.IP != "" {
+		addr = net.JoinHostPort(p.IP, addr)
+	}
+	return addr + "/" + p.Protocol
+}
+
+// offsetPorts moves the host side of the published ports of all the services
+// by the offset, so several instances of a project can run side by side.
+// Container ports, and ports left for docker to pick, are kept as they are.
+func offsetPorts(config yamlConfig, offset int) error {
+	if offset == 0 {
+		return nil
+	}
+
+	for name, configs := range config {
+		configs, ok := configs.(yamlConfig)
+		if !ok {
+			continue
+		}
+		ports, ok := configs["ports"].([]interface{})
+		if !ok {
+			continue
+		}
+		offsetted := make([]interface{}, len(ports))
+		for i, port := range ports {
+			port, err := offsetPort(port, offset)
+			if err != nil {
+				return fmt.Errorf("Error offsetting ports of service [%v]: %v", name, err)
+			}
+			offsetted[i] = port
+		}
+		configs["ports"] = offsetted
+	}
+	return nil
+}
+
+// offsetPort moves the host port of a port in either the short syntax, as in
+// "127.0.0.1:8080:80/tcp", or the long syntax with the published key.
+func offsetPort(port interface{}, offset int) (interface{}, error) {
+	switch port := port.(type) {
+	case string:
+		ip, published, target := splitPort(port)
+		if published == "" {
+			return port, nil
+		}
+		moved, err := offsetPortRange(published, offset)
+		if err != nil {
+			return nil, err
+		}
+		if ip != "" {
+			return ip + ":" + moved + ":" + target, nil
+		}
+		return moved + ":" + target, nil
+	case yamlConfig:
+		if port["published"] == nil {
+			return port, nil
+		}
+		moved, err := offsetPortRange(fmt.Sprint(port["published"]), offset)
+		if err != nil {
+			return nil, err
+		}
+		offsetted := yamlConfig{}
+		for key, value := range port {
+			offsetted[key] = value
+		}
+		if n, err := strconv.Atoi(moved); err == nil {
+			offsetted["published"] = n
+		} else {
+			offsetted["published"] = moved
+		}
+		return offsetted, nil
+	}
+	// A number is a container port only
+	return port, nil
+}
+
+// splitPort splits a port in the short syntax into the host IP, the host
+// port and the container port with its protocol. IPv6 addresses are given
+// in brackets, as in "[::1]:8080:80".
+func splitPort(port string) (ip, published, target string) {
+	n := strings.LastIndex(port, ":")
+	if n < 0 {
+		return "", "", port
+	}
+	target = port[n+1:]
+	host := port[:n]
+
+	n = strings.LastIndex(host, ":")
+	if n < 0 {
+		return "", host, target
+	}
+	if strings.HasSuffix(host, "]") {
+		// Only an IPv6 address, without host port
+		return host, "", target
+	}
+	return host[:n], host[n+1:], target
+}
+
+// offsetPortRange moves a port, or both ends of a range like "8080-8081"
+func offsetPortRange(ports string, offset int) (string, error) {
+	parts := strings.SplitN(ports, "-", 2)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return "", fmt.Errorf("Invalid port [%s]", ports)
+		}
+		if n+offset < 1 || n+offset > 65535 {
+			return "", fmt.Errorf("Port [%d] moved by %d is out of range", n, offset)
+		}
+		parts[i] = strconv.Itoa(n + offset)
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// getHostPorts lists the host ports published by the services
+func getHostPorts(services map[string]*Service) []hostPort {
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hostPorts := []hostPort{}
+	for _, name := range names {
+		ports, _ := services[name].Config["ports"].([]interface{})
+		for _, port := range ports {
+			var ip, published, protocol string
+			switch port := port.(type) {
+			case string:
+				ip, published, protocol = splitPort(port)
+				if n := strings.Index(protocol, "/"); n >= 0 {
+					protocol = protocol[n+1:]
+				} else {
+					protocol = ""
+				}
+			case yamlConfig:
+				if port["published"] != nil {
+					published = fmt.Sprint(port["published"])
+				}
+				if port["host_ip"] != nil {
+					ip = fmt.Sprint(port["host_ip"])
+				}
+				if port["protocol"] != nil {
+					protocol = fmt.Sprint(port["protocol"])
+				}
+			}
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			ip = strings.Trim(ip, "[]")
+
+			bounds := strings.SplitN(published, "-", 2)
+			first, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+			last := first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					continue
+				}
+			}
+			for p := first; p <= last; p++ {
+				hostPorts = append(hostPorts, hostPort{name, ip, protocol, p})
+			}
+		}
+	}
+	return hostPorts
+}
+
+// isPortFree tells whether the host port can be bound
+func isPortFree(p hostPort) bool {
+	addr := net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+	if p.Protocol == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// checkPorts reports the host ports the selected services publish on that
+// are already bound, before compose fails on the first one. Services with a
+// running container are skipped, as they hold their ports until recreated.
+func (d *Dcm) checkPorts() (int, error) {
+	services, err := d.Config.Services()
+	if err != nil {
+		return 1, err
+	}
+	if services, err = d.selectServices(services); err != nil {
+		return 1, err
+	}
+
+	busy := []hostPort{}
+	running := map[string]bool{}
+	for _, p := range getHostPorts(services) {
+		if isPortFree(p) {
+			continue
+		}
+		if _, ok := running[p.Service]; !ok {
+			running[p.Service], _ = d.isRunning(p.Service)
+		}
+		if !running[p.Service] {
+			busy = append(busy, p)
+		}
+	}
+	if len(busy) == 0 {
+		return 0, nil
+	}
+
+	for _, p := range busy {
+		fmt.Fprintf(d.Stdout, "service [%s]: host port [%s] is already in use\n", p.Service, p)
+	}
+	return 1, fmt.Errorf(
+		"Found %d host port(s) already in use, free them or change the port_offset setting",
+		len(busy),
+	)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAutoPortOffset(t *testing.T) {
+	for n, test := range []struct {
+		project string
+		offset  int
+	}{
+		{"dcm", 0},
+		{"instance1", 100},
+		{"billing-12", 1200},
+		{"2fa", 0},
+	} {
+		assert.Equal(t, test.offset, getAutoPortOffset(test.project), "[%d: %s] Incorrect offset returned", n, test.project)
+	}
+}
+
+func TestSplitPort(t *testing.T) {
+	for n, test := range []struct {
+		port, ip, published, target string
+	}{
+		{"80", "", "", "80"},
+		{"8080:80", "", "8080", "80"},
+		{"8080-8081:80-81/udp", "", "8080-8081", "80-81/udp"},
+		{"127.0.0.1:8080:80", "127.0.0.1", "8080", "80"},
+		{"127.0.0.1::80", "127.0.0.1", "", "80"},
+		{"[::1]:8080:80", "[::1]", "8080", "80"},
+		{"[::1]::80", "[::1]", "", "80"},
+	} {
+		ip, published, target := splitPort(test.port)
+		assert.Equal(t, test.ip, ip, "[%d: %s] Incorrect IP returned", n, test.port)
+		assert.Equal(t, test.published, published, "[%d: %s] Incorrect host port returned", n, test.port)
+		assert.Equal(t, test.target, target, "[%d: %s] Incorrect container port returned", n, test.port)
+	}
+}
+
+func TestOffsetPort(t *testing.T) {
+	fixtures := []struct {
+		name     string
+		port     interface{}
+		expected interface{}
+		err      error
+	}{
+		{
+			name:     "Container port only",
+			port:     80,
+			expected: 80,
+		},
+		{
+			name:     "Short syntax",
+			port:     "8080:80",
+			expected: "8180:80",
+		},
+		{
+			name:     "Short syntax with IP, range and protocol",
+			port:     "127.0.0.1:8080-8081:80-81/udp",
+			expected: "127.0.0.1:8180-8181:80-81/udp",
+		},
+		{
+			name:     "Host port picked by docker",
+			port:     "127.0.0.1::80",
+			expected: "127.0.0.1::80",
+		},
+		{
+			name:     "Long syntax",
+			port:     yamlConfig{"target": 80, "published": 8080, "protocol": "tcp"},
+			expected: yamlConfig{"target": 80, "published": 8180, "protocol": "tcp"},
+		},
+		{
+			name:     "Long syntax with a range",
+			port:     yamlConfig{"target": 80, "published": "8080-8081"},
+			expected: yamlConfig{"target": 80, "published": "8180-8181"},
+		},
+		{
+			name:     "Long syntax without host port",
+			port:     yamlConfig{"target": 80},
+			expected: yamlConfig{"target": 80},
+		},
+		{
+			name: "Negative case: invalid port",
+			port: "http:80",
+			err:  errors.New("Invalid port [http]"),
+		},
+		{
+			name: "Negative case: out of range",
+			port: "65500:80",
+			err:  errors.New("Port [65500] moved by 100 is out of range"),
+		},
+	}
+
+	for n, test := range fixtures {
+		port, err := offsetPort(test.port, 100)
+		if test.err != nil {
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.expected, port, "[%d: %s] Incorrect port returned", n, test.name)
+	}
+
+	config := yamlConfig{
+		"web": yamlConfig{"ports": []interface{}{"8080:80", "443"}},
+		"db":  yamlConfig{"image": "mysql"},
+	}
+	assert.NoError(t, offsetPorts(config, 200))
+	assert.Equal(t, []interface{}{"8280:80", "443"}, config["web"].(yamlConfig)["ports"])
+
+	config = yamlConfig{"web": yamlConfig{"ports": []interface{}{"web:80"}}}
+	assert.EqualError(t, offsetPorts(config, 200), "Error offsetting ports of service [web]: Invalid port [web]")
+}
+
+func TestGetHostPorts(t *testing.T) {
+	services := map[string]*Service{
+		"web": {Config: yamlConfig{"ports": []interface{}{
+			"80",
+			"8080-8081:80-81",
+			"[::1]:8443:443",
+			yamlConfig{"target": 53, "published": 5353, "protocol": "udp", "host_ip": "127.0.0.1"},
+		}}},
+		"db": {Config: yamlConfig{"ports": []interface{}{"127.0.0.1:3306:3306/tcp"}}},
+	}
+
+	assert.Equal(t, []hostPort{
+		{"db", "127.0.0.1", "tcp", 3306},
+		{"web", "", "tcp", 8080},
+		{"web", "", "tcp", 8081},
+		{"web", "::1", "tcp", 8443},
+		{"web", "127.0.0.1", "udp", 5353},
+	}, getHostPorts(services))
+	assert.Equal(t, "[::1]:8443/tcp", hostPort{"web", "::1", "tcp", 8443}.String())
+	assert.Equal(t, "8080/tcp", hostPort{"web", "", "tcp", 8080}.String())
+}
+
+func TestCheckPorts(t *testing.T) {
+	var out bytes.Buffer
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	busy := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	engine, server := helperEngine(t, newFakeEngine())
+	defer server.Close()
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Cmd = &CmdMock{}
+	dcm.Engine = engine
+	dcm.Stdout = &out
+	dcm.Config.Project = "dcmtest"
+	// The port of a running service is its own
+	dcm.Config.Config = yamlConfig{
+		"web": yamlConfig{"ports": []interface{}{"127.0.0.1:" + busy + ":80"}},
+		"ok":  yamlConfig{"ports": []interface{}{"127.0.0.1:" + busy + ":8080"}},
+		"api": yamlConfig{},
+	}
+
+	code, err := dcm.checkPorts()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Found 1 host port(s) already in use, free them or change the port_offset setting")
+	assert.Equal(t, "service [web]: host port [127.0.0.1:"+busy+"/tcp] is already in use\n", out.String())
+
+	// The services left out are not checked
+	out.Reset()
+	dcm.Selector = selector{Services: []string{"api"}}
+	code, err = dcm.checkPorts()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultProject = "dcm"
+
+	// stateFile keeps the local state of the projects of a folder, relative
+	// to the folder
+	stateFile = ".dcm/state.yml"
+)
+
+// state is the content of the state file
+type state struct {
+	Project string `yaml:"project,omitempty"`
+}
+
+func readState(file string) (state, error) {
+	var s state
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := yaml.Unmarshal(content, &s); err != nil {
+		return s, fmt.Errorf("Error parsing [%s]: %v", file, err)
+	}
+	return s, nil
+}
+
+func writeState(file string, s state) error {
+	content, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+// loadState applies the project selected with `dcm project use` in the
+// folder of the project. It takes precedence over the .dcmrc file, but not
+// over the environment or the options.
+func (c *Config) loadState() error {
+	origin := c.Origins["project"]
+	fromState := strings.HasSuffix(origin, stateFile)
+	if !c.isDefault("project") && !fromState && filepath.Base(origin) != ".dcmrc" {
+		return nil
+	}
+
+	file := filepath.Join(c.Dir, stateFile)
+	s, err := readState(file)
+	if err != nil {
+		return err
+	}
+	if s.Project != "" {
+		c.Project = s.Project
+		c.setOrigin("project", file)
+	} else if fromState {
+		// The folder changed since the state was read, and the new one
+		// has no project selected
+		c.Project = defaultProject
+		c.setOrigin("project", originDefault)
+	}
+	return nil
+}
+
+func (d *Dcm) Project(args ...string) (int, error) {
+	// The config file isn't loaded, as it may be the one missing, but
+	// the folder of the project is still looked for
+	wd, _ := os.Getwd()
+	if err := d.Config.discover(wd); err != nil {
+		return 1, err
+	}
+	if err := d.Config.loadState(); err != nil {
+		return 1, err
+	}
+	d.Config.setLocations()
+
+	// Options alone, as in `dcm project -o json`, are the ones of list
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"list"}, args...)
+	}
+
+	switch args[0] {
+	case "ls", "list":
+		return d.projectList(args[1:]...)
+	case "current":
+		return d.projectCurrent(args[1:]...)
+	case "use":
+		if len(args) < 2 {
+			return 1, errors.New("Error: no project name specified.")
+		}
+		return d.projectUse(args[1])
+	case "create":
+		return d.projectCreate(args[1:]...)
+	default:
+		return 1, fmt.Errorf("Unknown project command [%s]", args[0])
+	}
+}
+
+// composeFileNames are the compose files that can sit next to the projects,
+// like the one `dcm init` reads, and are never projects themselves
+var composeFileNames = []string{"docker-compose", "compose"}
+
+// isProjectName tells whether <name>.yml is the config file of a project,
+// and not an override file, a compose file, a hidden file or a file in
+// another folder
+func isProjectName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) &&
+		!strings.HasSuffix(name, ".override") && !strings.HasSuffix(name, ".local") &&
+		!strings.HasPrefix(name, ".") && !inSlice(name, composeFileNames)
+}
+
+// getProjects returns the names of the projects in the folder, one for each
+// config file that is not an override file or a compose file.
+func getProjects(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	projects := []string{}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".yml")
+		if isProjectName(name) {
+			projects = append(projects, name)
+		}
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// projectEntry is a project, as printed by `dcm project list`
+type projectEntry struct {
+	Name    string `yaml:"name" json:"name"`
+	Current bool   `yaml:"current" json:"current"`
+}
+
+func (d *Dcm) projectList(args ...string) (int, error) {
+	output, err := parseProjectOutput(args)
+	if err != nil {
+		return 1, err
+	}
+	projects, err := getProjects(d.Config.Dir)
+	if err != nil {
+		return 1, err
+	}
+
+	entries := []projectEntry{}
+	for _, project := range projects {
+		entries = append(entries, projectEntry{Name: project, Current: project == d.Config.Project})
+	}
+	switch output {
+	case outputText:
+		for _, e := range entries {
+			if e.Current {
+				fmt.Fprintln(d.Stdout, "* "+e.Name)
+			} else {
+				fmt.Fprintln(d.Stdout, "  "+e.Name)
+			}
+		}
+	case outputTable:
+		rows := [][]string{}
+		for _, e := range entries {
+			current := ""
+			if e.Current {
+				current = "*"
+			}
+			rows = append(rows, []string{e.Name, current})
+		}
+		err = writeTable(d.Stdout, []string{"PROJECT", "CURRENT"}, rows)
+	default:
+		err = writeOutput(d.Stdout, output, entries)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func (d *Dcm) projectCurrent(args ...string) (int, error) {
+	output, err := parseProjectOutput(args)
+	if err != nil {
+		return 1, err
+	}
+	entry := projectEntry{Name: d.Config.Project, Current: true}
+	switch output {
+	case outputText:
+		fmt.Fprintln(d.Stdout, entry.Name)
+	case outputTable:
+		err = writeTable(d.Stdout, []string{"PROJECT", "CURRENT"}, [][]string{{entry.Name, "*"}})
+	default:
+		err = writeOutput(d.Stdout, output, entry)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// parseProjectOutput reads the output format of the read-only project
+// commands, which take no other argument
+func parseProjectOutput(args []string) (string, error) {
+	output, args, err := parseOutputOption(args, outputText)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > 0 {
+		return "", fmt.Errorf("Unknown argument [%s]", args[0])
+	}
+	return output, checkOutputFormat(output, outputText, outputTable, outputJSON, outputYAML)
+}
+
+func (d *Dcm) projectUse(project string) (int, error) {
+	if !isProjectName(project) {
+		return 1, fmt.Errorf("Invalid project name [%s]", project)
+	}
+	if !isFile(filepath.Join(d.Config.Dir, project+".yml")) {
+		return 1, fmt.Errorf("Project [%s] not found in [%s]", project, d.Config.Dir)
+	}
+
+	file := filepath.Join(d.Config.Dir, stateFile)
+	s, err := readState(file)
+	if err != nil {
+		return 1, err
+	}
+	s.Project = project
+	if err := writeState(file, s); err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(d.Stdout, "Switched to project [%s].\n", project)
+
+	if env := os.Getenv("DCM_PROJECT"); env != "" && env != project {
+		fmt.Fprintf(d.Stdout, "Note: $DCM_PROJECT is set to [%s] and takes precedence, unset it to use [%s].\n", env, project)
+	}
+	return 0, nil
+}
+
+// projectCreate creates a new instance of a project, copying its config file
+// and its override file. The repos are checked out by `dcm setup` in the
+// srv folder of the new project.
+func (d *Dcm) projectCreate(args ...string) (int, error) {
+	var from string
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&from, "from", d.Config.Project, "")
+
+	// The name can be given either before or after the options
+	if err := fs.Parse(args); err != nil {
+		return 1, fmt.Errorf("Error parsing options: %v", err)
+	}
+	if fs.NArg() == 0 {
+		return 1, errors.New("Error: no project name specified.")
+	}
+	project := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return 1, fmt.Errorf("Error parsing options: %v", err)
+	}
+	if fs.NArg() > 0 {
+		return 1, fmt.Errorf("Error: only one project name expected, got [%s].", strings.Join(fs.Args(), " "))
+	}
+	if !isProjectName(project) {
+		return 1, fmt.Errorf("Invalid project name [%s]", project)
+	}
+
+	dir := d.Config.Dir
+	if !isFile(filepath.Join(dir, from+".yml")) {
+		return 1, fmt.Errorf("Project [%s] not found in [%s]", from, dir)
+	}
+	// Any file left over, like the override file of a deleted project,
+	// would be merged into the new project
+	suffixes := []string{".yml", ".override.yml"}
+	for _, suffix := range append(suffixes, ".local.yml") {
+		if isFile(filepath.Join(dir, project+suffix)) {
+			return 1, fmt.Errorf("Project [%s] already exists in [%s]: found [%s]", project, dir, project+suffix)
+		}
+	}
+
+	for _, suffix := range suffixes {
+		src := filepath.Join(dir, from+suffix)
+		if !isFile(src) {
+			continue
+		}
+		content, err := ioutil.ReadFile(src)
+		if err != nil {
+			return 1, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, project+suffix), content, 0644); err != nil {
+			return 1, err
+		}
+	}
+
+	fmt.Fprintf(d.Stdout, "Created project [%s] from [%s]. Run `dcm project use %s` to switch to it.\n", project, from, project)
+	return 0, nil
+}
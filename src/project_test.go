@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureProjectFiles are the config files of the default project, and of
+// instance1 with its override files
+var fixtureProjectFiles = map[string]string{
+	"dcm.yml":                "web:\n  image: nginx\n",
+	"instance1.yml":          "web:\n  image: nginx:2\n",
+	"instance1.override.yml": "web:\n  ports: [\"81:80\"]\n",
+	"instance1.local.yml":    "web:\n  ports: [\"82:80\"]\n",
+}
+
+func TestProject(t *testing.T) {
+	dcm, out, dir := helperCreateTestDcm(t, "", fixtureProjectFiles)
+	defer os.RemoveAll(dir)
+
+	code, err := dcm.Project()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "* dcm\n  instance1\n", out.String())
+
+	out.Reset()
+	code, err = dcm.Project("use", "instance1")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "Switched to project [instance1].\n", out.String())
+	content, err := ioutil.ReadFile(dir + "/.dcm/state.yml")
+	require.Nil(t, err)
+	assert.Equal(t, "project: instance1\n", string(content))
+
+	// The project is now used by default
+	out.Reset()
+	dcm = NewDcm(NewConfig().loadOptions(Options{Dir: dir}), []string{})
+	dcm.Stdout = out
+	code, err = dcm.Project("current")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "instance1\n", out.String())
+	assert.Equal(t, dir+"/.dcm/state.yml", dcm.Config.Origins["project"])
+	assert.Equal(t, dir+"/instance1.yml", dcm.Config.File)
+
+	// The environment still takes precedence
+	os.Setenv("DCM_PROJECT", "dcm")
+	defer os.Unsetenv("DCM_PROJECT")
+	out.Reset()
+	dcm = NewDcm(NewConfig().loadOptions(Options{Dir: dir}), []string{})
+	dcm.Stdout = out
+	code, err = dcm.Project("use", "instance1")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"Switched to project [instance1].\n"+
+		"Note: $DCM_PROJECT is set to [dcm] and takes precedence, unset it to use [instance1].\n",
+		out.String())
+	assert.Equal(t, "dcm", dcm.Config.Project)
+
+	// Negative cases
+	for n, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"use"}, "Error: no project name specified."},
+		{[]string{"use", "missing"}, "Project [missing] not found in [" + dir + "]"},
+		{[]string{"use", "docker-compose"}, "Invalid project name [docker-compose]"},
+		{[]string{"use", "../dcm"}, "Invalid project name [../dcm]"},
+		{[]string{"remove", "dcm"}, "Unknown project command [remove]"},
+		{[]string{"list", "extra"}, "Unknown argument [extra]"},
+		{[]string{"current", "-o", "xml"}, "Invalid output format [xml], must be one of: text, table, json, yaml"},
+	} {
+		code, err := dcm.Project(test.args...)
+		assert.Equal(t, 1, code, "[%d: %v] Incorrect error code returned", n, test.args)
+		assert.EqualError(t, err, test.err, "[%d: %v] Incorrect error returned", n, test.args)
+	}
+}
+
+func TestProjectOutput(t *testing.T) {
+	dcm, out, dir := helperCreateTestDcm(t, "", fixtureProjectFiles)
+	defer os.RemoveAll(dir)
+
+	for n, test := range []struct {
+		args []string
+		out  string
+	}{
+		{
+			[]string{"-o", "table"},
+			"PROJECT    CURRENT\ndcm        *\ninstance1  -\n",
+		},
+		{
+			[]string{"list", "--output", "json"},
+			"[\n  {\n    \"name\": \"dcm\",\n    \"current\": true\n  },\n  {\n    \"name\": \"instance1\",\n    \"current\": false\n  }\n]\n",
+		},
+		{
+			[]string{"current", "-o", "yaml"},
+			"name: dcm\ncurrent: true\n",
+		},
+	} {
+		out.Reset()
+		code, err := dcm.Project(test.args...)
+		assert.Equal(t, 0, code, "[%d: %v] Incorrect error code returned", n, test.args)
+		assert.NoError(t, err, "[%d: %v] Non-nil error returned", n, test.args)
+		assert.Equal(t, test.out, out.String(), "[%d: %v] Incorrect output", n, test.args)
+	}
+}
+
+func TestProjectCreate(t *testing.T) {
+	dcm, out, dir := helperCreateTestDcm(t, "", fixtureProjectFiles)
+	defer os.RemoveAll(dir)
+
+	// From the current project by default
+	code, err := dcm.Project("create", "instance2")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "Created project [instance2] from [dcm]. Run `dcm project use instance2` to switch to it.\n", out.String())
+	content, err := ioutil.ReadFile(dir + "/instance2.yml")
+	require.Nil(t, err)
+	assert.Equal(t, "web:\n  image: nginx\n", string(content))
+
+	// The override file is copied too, the local one is not
+	code, err = dcm.Project("create", "instance3", "--from", "instance1")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	content, err = ioutil.ReadFile(dir + "/instance3.override.yml")
+	require.Nil(t, err)
+	assert.Equal(t, "web:\n  ports: [\"81:80\"]\n", string(content))
+	_, err = os.Stat(dir + "/instance3.local.yml")
+	assert.True(t, os.IsNotExist(err))
+
+	// The compose files and the hidden files are not projects
+	for _, file := range []string{"docker-compose.yml", "compose.yml", ".travis.yml", "leftover.override.yml"} {
+		require.Nil(t, ioutil.WriteFile(dir+"/"+file, []byte("web:\n  image: nginx\n"), 0644))
+	}
+	projects, err := getProjects(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dcm", "instance1", "instance2", "instance3"}, projects)
+
+	// Negative cases
+	for n, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"create"}, "Error: no project name specified."},
+		{[]string{"create", "instance1"}, "Project [instance1] already exists in [" + dir + "]: found [instance1.yml]"},
+		{[]string{"create", "leftover"}, "Project [leftover] already exists in [" + dir + "]: found [leftover.override.yml]"},
+		{[]string{"create", "instance4", "instance5"}, "Error: only one project name expected, got [instance5]."},
+		{[]string{"create", "docker-compose"}, "Invalid project name [docker-compose]"},
+		{[]string{"create", "sub/instance4"}, "Invalid project name [sub/instance4]"},
+		{[]string{"create", `sub\instance4`}, `Invalid project name [sub\instance4]`},
+		{[]string{"create", ".."}, "Invalid project name [..]"},
+		{[]string{"create", "."}, "Invalid project name [.]"},
+		{[]string{"create", "--from", "missing", "instance4"}, "Project [missing] not found in [" + dir + "]"},
+		{[]string{"create", "instance4", "--force"}, "Error parsing options: flag provided but not defined: -force"},
+	} {
+		code, err := dcm.Project(test.args...)
+		assert.Equal(t, 1, code, "[%d: %v] Incorrect error code returned", n, test.args)
+		assert.EqualError(t, err, test.err, "[%d: %v] Incorrect error returned", n, test.args)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(other)
+	require.Nil(t, writeState(dir+"/.dcm/state.yml", state{Project: "instance1"}))
+
+	// The state is applied over the default and the .dcmrc file
+	c := &Config{Dir: dir, Project: "dcm"}
+	c.setOrigin("project", dir+"/.dcmrc")
+	assert.NoError(t, c.loadState())
+	assert.Equal(t, "instance1", c.Project)
+	assert.Equal(t, dir+"/.dcm/state.yml", c.Origins["project"])
+
+	// And reverted when the folder changes to one without state
+	c.Dir = other
+	assert.NoError(t, c.loadState())
+	assert.Equal(t, "dcm", c.Project)
+	assert.Equal(t, originDefault, c.Origins["project"])
+
+	// But not over the options
+	c = &Config{Dir: dir, Project: "dcm"}
+	c.setOrigin("project", "--project")
+	assert.NoError(t, c.loadState())
+	assert.Equal(t, "dcm", c.Project)
+
+	// Negative case: invalid state file
+	require.Nil(t, os.Mkdir(other+"/.dcm", 0777))
+	require.Nil(t, ioutil.WriteFile(other+"/.dcm/state.yml", []byte("project: [1"), 0644))
+	c = &Config{Dir: other, Project: "dcm"}
+	assert.Error(t, c.loadState())
+}
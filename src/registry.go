@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubRegistry is the key of the Docker Hub credentials in the docker
+// config file
+const dockerHubRegistry = "https://index.docker.io/v1/"
+
+// dockerConfig is the part of the docker CLI config file with the registry
+// credentials and the current context
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredsStore     string            `json:"credsStore"`
+	CredHelpers    map[string]string `json:"credHelpers"`
+	CurrentContext string            `json:"currentContext"`
+}
+
+// getDockerConfigDir returns the folder of the docker CLI config:
+// DOCKER_CONFIG, or ~/.docker
+func getDockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// readDockerConfig reads the config file of the docker CLI, which is empty
+// when there is none
+func readDockerConfig() (dockerConfig, error) {
+	var config dockerConfig
+	content, err := ioutil.ReadFile(filepath.Join(getDockerConfigDir(), "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(content, &config)
+	return config, err
+}
+
+// getImageRegistry returns the registry of an image, as the docker config
+// file names it. The Docker Hub is named the same way whether the image
+// names it or not, e.g. docker.io/library/nginx.
+func getImageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") &&
+		parts[0] != "docker.io" && parts[0] != "index.docker.io" {
+		return parts[0]
+	}
+	return dockerHubRegistry
+}
+
+// getRegistryAuth returns the credentials the docker CLI would use to pull
+// the image, encoded for the X-Registry-Auth header. They come from either
+// a credential helper or the config file itself, and are empty for
+// anonymous pulls.
+func (d *Dcm) getRegistryAuth(image string) (string, error) {
+	config, err := readDockerConfig()
+	if err != nil {
+		return "", err
+	}
+
+	registry := getImageRegistry(image)
+	var username, secret string
+	helper := config.CredsStore
+	if config.CredHelpers[registry] != "" {
+		helper = config.CredHelpers[registry]
+	}
+	if helper != "" {
+		username, secret = d.getHelperCredentials(helper, registry)
+	} else if auth, ok := config.Auths[registry]; ok {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", err
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 {
+			username, secret = parts[0], parts[1]
+		}
+	}
+	if secret == "" {
+		return "", nil
+	}
+
+	credentials := map[string]string{"serveraddress": registry}
+	if username == "<token>" {
+		credentials["identitytoken"] = secret
+	} else {
+		credentials["username"] = username
+		credentials["password"] = secret
+	}
+	encoded, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+// getHelperCredentials asks a docker credential helper for the credentials
+// of the registry. Registries the helper knows nothing about are pulled
+// from anonymously, as the docker CLI does.
+func (d *Dcm) getHelperCredentials(helper, registry string) (string, string) {
+	var out bytes.Buffer
+	err := d.Cmd.Clone().
+		Exec("docker-credential-"+helper, "get").
+		SetStdin(strings.NewReader(registry)).
+		SetStdout(&out).
+		SetStderr(ioutil.Discard).
+		Run()
+	if err != nil {
+		return "", ""
+	}
+
+	var credentials struct {
+		Username, Secret string
+	}
+	if json.Unmarshal(out.Bytes(), &credentials) != nil {
+		return "", ""
+	}
+	return credentials.Username, credentials.Secret
+}
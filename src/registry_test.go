@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetImageRegistry(t *testing.T) {
+	for n, test := range []struct {
+		image, registry string
+	}{
+		{"nginx", dockerHubRegistry},
+		{"library/nginx:1.25", dockerHubRegistry},
+		{"docker.io/library/nginx", dockerHubRegistry},
+		{"index.docker.io/team/api:1.0", dockerHubRegistry},
+		{"registry.local/team/api", "registry.local"},
+		{"registry:5000/api", "registry:5000"},
+		{"localhost/api", "localhost"},
+	} {
+		assert.Equal(t, test.registry, getImageRegistry(test.image), "[%d: %s] Incorrect registry returned", n, test.image)
+	}
+}
+
+func TestGetRegistryAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	dcm := NewDcm(NewConfig(), []string{})
+
+	// Positive case: no config file, anonymous pulls
+	auth, err := dcm.getRegistryAuth("nginx")
+	assert.NoError(t, err)
+	assert.Empty(t, auth)
+
+	// Negative case: invalid config file
+	require.Nil(t, ioutil.WriteFile(dir+"/config.json", []byte("{"), 0644))
+	_, err = dcm.getRegistryAuth("nginx")
+	assert.EqualError(t, err, "unexpected end of JSON input")
+
+	encode := func(credentials string) string {
+		return base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	require.Nil(t, ioutil.WriteFile(dir+"/config.json", []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "`+encode("user:secret")+`"},
+			"registry.local": {"auth": "`+encode("<token>:identity")+`"},
+			"broken.local": {"auth": "!"}
+		},
+		"credHelpers": {"helper.local": "dcmtest-missing"}
+	}`), 0644))
+
+	fixtures := []struct {
+		name, image string
+		credentials map[string]string
+		err         string
+	}{
+		{
+			name:  "Positive case: Docker Hub credentials",
+			image: "nginx",
+			credentials: map[string]string{
+				"serveraddress": dockerHubRegistry,
+				"username":      "user",
+				"password":      "secret",
+			},
+		},
+		{
+			name:  "Positive case: identity token",
+			image: "registry.local/team/api",
+			credentials: map[string]string{
+				"serveraddress": "registry.local",
+				"identitytoken": "identity",
+			},
+		},
+		{
+			name:  "Positive case: unknown registry, anonymous pulls",
+			image: "other.local/api",
+		},
+		{
+			name:  "Positive case: credential helper failing, anonymous pulls",
+			image: "helper.local/api",
+		},
+		{
+			name:  "Negative case: invalid credentials",
+			image: "broken.local/api",
+			err:   "illegal base64 data at input byte 0",
+		},
+	}
+
+	for n, test := range fixtures {
+		auth, err := dcm.getRegistryAuth(test.image)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		if test.credentials == nil {
+			assert.Empty(t, auth, "[%d: %s] Incorrect auth returned", n, test.name)
+			continue
+		}
+		decoded, err := base64.URLEncoding.DecodeString(auth)
+		require.Nil(t, err)
+		var credentials map[string]string
+		require.Nil(t, json.Unmarshal(decoded, &credentials))
+		assert.Equal(t, test.credentials, credentials, "[%d: %s] Incorrect credentials returned", n, test.name)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// renderDir keeps the compose files rendered for the projects of a folder,
+// relative to the folder
+const renderDir = ".dcm"
+
+// getRenderedFile returns where the compose file of the project is rendered
+func (c *Config) getRenderedFile() string {
+	return filepath.Join(c.Dir, renderDir, c.Project, "docker-compose.yml")
+}
+
+// getRenderedDoc returns the resolved config as a compose document, in the
+// format of the config file. The values are already interpolated, so the
+// dollar signs left are escaped for compose not to interpolate them again.
+func (c *Config) getRenderedDoc() interface{} {
+	services := c.getRenderedServices()
+	if !c.HasSections {
+		return escapeDollars(services)
+	}
+
+	doc := yamlConfig{"services": services}
+	for key, value := range c.Others {
+		doc[key] = value
+	}
+	if c.Version != "" {
+		doc["version"] = c.Version
+	}
+	for key, section := range map[string]yamlConfig{
+		"volumes":  c.Volumes,
+		"networks": c.Networks,
+		"secrets":  c.Secrets,
+	} {
+		if section != nil {
+			doc[key] = section
+		}
+	}
+	return escapeDollars(doc)
+}
+
+// getRenderedServices returns the services as compose sees them: the
+// relative host paths are resolved against the folder of the project, and
+// the dcm labels, only meant for DCM, are left out.
+func (c *Config) getRenderedServices() yamlConfig {
+	services := yamlConfig{}
+	for name, configs := range c.Config {
+		configs, ok := configs.(yamlConfig)
+		if !ok {
+			services[name] = configs
+			continue
+		}
+		rendered := yamlConfig{}
+		for key, value := range configs {
+			rendered[key] = value
+		}
+
+		if labels, ok := configs["labels"].(yamlConfig); ok {
+			kept := yamlConfig{}
+			for label, value := range labels {
+				if !strings.HasPrefix(fmt.Sprint(label), "dcm.") {
+					kept[label] = value
+				}
+			}
+			rendered["labels"] = kept
+			if len(kept) == 0 {
+				delete(rendered, "labels")
+			}
+		}
+		c.resolveBuild(rendered)
+		c.resolveEnvFiles(rendered)
+		c.resolveVolumes(rendered)
+
+		services[name] = rendered
+	}
+	return services
+}
+
+// resolvePath makes a host path relative to the folder of the project
+// absolute, leaving the other ones as they are.
+func (c *Config) resolvePath(p string) string {
+	if p == "." || p == ".." || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") {
+		return filepath.Join(c.Dir, p)
+	}
+	return p
+}
+
+// resolveBuild resolves the build context, unless it is a URL
+func (c *Config) resolveBuild(configs yamlConfig) {
+	resolve := func(context string) string {
+		if filepath.IsAbs(context) || strings.Contains(context, "://") || strings.HasPrefix(context, "git@") {
+			return context
+		}
+		return filepath.Join(c.Dir, context)
+	}
+
+	switch build := configs["build"].(type) {
+	case string:
+		configs["build"] = resolve(build)
+	case yamlConfig:
+		context, ok := build["context"].(string)
+		if !ok {
+			return
+		}
+		resolved := yamlConfig{}
+		for key, value := range build {
+			resolved[key] = value
+		}
+		resolved["context"] = resolve(context)
+		configs["build"] = resolved
+	}
+}
+
+// resolveEnvFiles resolves the env_file entries, which are always paths
+func (c *Config) resolveEnvFiles(configs yamlConfig) {
+	resolve := func(file string) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(c.Dir, file)
+	}
+
+	switch files := configs["env_file"].(type) {
+	case string:
+		configs["env_file"] = resolve(files)
+	case []interface{}:
+		resolved := make([]interface{}, len(files))
+		for i, file := range files {
+			if file, ok := file.(string); ok {
+				resolved[i] = resolve(file)
+			} else {
+				resolved[i] = file
+			}
+		}
+		configs["env_file"] = resolved
+	}
+}
+
+// resolveVolumes resolves the host paths of the bind mounts, in either the
+// short syntax, as in "./data:/data:ro", or the long syntax. Named volumes
+// are kept as they are.
+func (c *Config) resolveVolumes(configs yamlConfig) {
+	volumes, ok := configs["volumes"].([]interface{})
+	if !ok {
+		return
+	}
+
+	resolved := make([]interface{}, len(volumes))
+	for i, volume := range volumes {
+		switch volume := volume.(type) {
+		case string:
+			parts := strings.SplitN(volume, ":", 2)
+			parts[0] = c.resolvePath(parts[0])
+			resolved[i] = strings.Join(parts, ":")
+		case yamlConfig:
+			source, ok := volume["source"].(string)
+			if !ok || volume["type"] != "bind" {
+				resolved[i] = volume
+				continue
+			}
+			mount := yamlConfig{}
+			for key, value := range volume {
+				mount[key] = value
+			}
+			mount["source"] = c.resolvePath(source)
+			resolved[i] = mount
+		default:
+			resolved[i] = volume
+		}
+	}
+	configs["volumes"] = resolved
+}
+
+// renderCompose writes the resolved config to the rendered compose file, and
+// returns the path of the file.
+func (c *Config) renderCompose() (string, error) {
+	content, err := yaml.Marshal(c.getRenderedDoc())
+	if err != nil {
+		return "", err
+	}
+	file := c.getRenderedFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, content, 0644)
+}
+
+func escapeDollars(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return strings.Replace(value, "$", "$$", -1)
+	case yamlConfig:
+		escaped := make(yamlConfig, len(value))
+		for key, item := range value {
+			escaped[key] = escapeDollars(item)
+		}
+		return escaped
+	case []interface{}:
+		escaped := make([]interface{}, len(value))
+		for i, item := range value {
+			escaped[i] = escapeDollars(item)
+		}
+		return escaped
+	}
+	return value
+}
+
+// Render renders the compose file handed to compose, and prints it
+func (d *Dcm) Render(args ...string) (int, error) {
+	if len(args) > 0 {
+		return 1, fmt.Errorf("Unknown argument [%s]", args[0])
+	}
+
+	file, err := d.Config.renderCompose()
+	if err != nil {
+		return 1, fmt.Errorf("Error rendering compose file [%s]: %v", d.Config.getRenderedFile(), err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(d.Stdout, "# %s\n%s", file, content)
+	return 0, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderCompose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Dir: dir, Project: "instance1", HasSections: true, Version: "2"}
+	c.Config = yamlConfig{"web": yamlConfig{
+		"image":   "nginx",
+		"command": "echo $HOME",
+		"ports":   []interface{}{"8180:80"},
+	}}
+	c.Volumes = yamlConfig{"data": nil}
+	c.Others = yamlConfig{"configs": yamlConfig{"site": yamlConfig{"file": "./site.conf"}}}
+
+	file, err := c.renderCompose()
+	require.Nil(t, err)
+	assert.Equal(t, dir+"/.dcm/instance1/docker-compose.yml", file)
+	content, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	assert.Equal(t, ""+
+		"configs:\n"+
+		"  site:\n"+
+		"    file: ./site.conf\n"+
+		"services:\n"+
+		"  web:\n"+
+		"    command: echo $$HOME\n"+
+		"    image: nginx\n"+
+		"    ports:\n"+
+		"    - 8180:80\n"+
+		"version: \"2\"\n"+
+		"volumes:\n"+
+		"  data: null\n",
+		string(content))
+
+	// Version 1 files keep the services at the top level
+	c.HasSections = false
+	assert.Equal(t, yamlConfig{"web": yamlConfig{
+		"image":   "nginx",
+		"command": "echo $$HOME",
+		"ports":   []interface{}{"8180:80"},
+	}}, c.getRenderedDoc())
+}
+
+func TestGetRenderedServices(t *testing.T) {
+	c := &Config{Dir: "/test/dcm/dir"}
+	c.Config = yamlConfig{
+		"api": yamlConfig{
+			"build":    "./srv/api",
+			"env_file": []interface{}{".env", "/etc/api.env"},
+			"volumes": []interface{}{
+				"./data:/data:ro",
+				"../logs:/logs",
+				"cache:/cache",
+				"/var/run/docker.sock:/var/run/docker.sock",
+				yamlConfig{"type": "bind", "source": "./conf", "target": "/conf"},
+				yamlConfig{"type": "volume", "source": "db", "target": "/db"},
+			},
+			"labels": yamlConfig{"dcm.repository": "git@github.com:dcm/api.git", "tier": "api"},
+		},
+		"web": yamlConfig{
+			"build":    yamlConfig{"context": "web", "dockerfile": "Dockerfile.dev"},
+			"env_file": "web.env",
+			"labels":   yamlConfig{"dcm.branch": "develop"},
+		},
+		"remote": yamlConfig{"build": "https://github.com/dcm/remote.git"},
+	}
+
+	assert.Equal(t, yamlConfig{
+		"api": yamlConfig{
+			"build":    "/test/dcm/dir/srv/api",
+			"env_file": []interface{}{"/test/dcm/dir/.env", "/etc/api.env"},
+			"volumes": []interface{}{
+				"/test/dcm/dir/data:/data:ro",
+				"/test/dcm/logs:/logs",
+				"cache:/cache",
+				"/var/run/docker.sock:/var/run/docker.sock",
+				yamlConfig{"type": "bind", "source": "/test/dcm/dir/conf", "target": "/conf"},
+				yamlConfig{"type": "volume", "source": "db", "target": "/db"},
+			},
+			"labels": yamlConfig{"tier": "api"},
+		},
+		"web": yamlConfig{
+			"build":    yamlConfig{"context": "/test/dcm/dir/web", "dockerfile": "Dockerfile.dev"},
+			"env_file": "/test/dcm/dir/web.env",
+		},
+		"remote": yamlConfig{"build": "https://github.com/dcm/remote.git"},
+	}, c.getRenderedServices())
+
+	// The loaded config is left untouched
+	assert.Equal(t, "./srv/api", c.Config["api"].(yamlConfig)["build"])
+	assert.Equal(t, yamlConfig{"dcm.branch": "develop"}, c.Config["web"].(yamlConfig)["labels"])
+}
+
+func TestRender(t *testing.T) {
+	var out bytes.Buffer
+
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Stdout = &out
+	dcm.Config.Dir = dir
+	dcm.Config.Project = "instance1"
+	dcm.Config.Config = yamlConfig{"web": yamlConfig{"image": "nginx"}}
+
+	code, err := dcm.Render()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "# "+dir+"/.dcm/instance1/docker-compose.yml\nweb:\n  image: nginx\n", out.String())
+
+	code, err = dcm.Render("web")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown argument [web]")
+
+	// Negative case: the folder of the project can't be written
+	require.Nil(t, ioutil.WriteFile(dir+"/file", []byte(""), 0644))
+	dcm.Config.Dir = dir + "/file"
+	code, err = dcm.Render()
+	assert.Equal(t, 1, code)
+	assert.Contains(t, err.Error(), "Error rendering compose file ["+dir+"/file/.dcm/instance1/docker-compose.yml]: ")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// selector picks the services a command acts on. Services matching any of
+// the names, groups or labels are selected along with the services they
+// depend on, unless NoDeps is set, then the exceptions are removed. An
+// empty selector selects all the services.
+type selector struct {
+	Services, Groups, Labels, Except []string
+	// NoDeps is set by the commands working on the checkouts, which don't
+	// need the services the selected ones depend on
+	NoDeps bool
+}
+
+func (s selector) IsEmpty() bool {
+	return len(s.Services) == 0 && len(s.Groups) == 0 &&
+		len(s.Labels) == 0 && len(s.Except) == 0
+}
+
+// parseSelector takes the selector options out of the command arguments.
+// Options can be repeated, and given either as "--group billing" or as
+// "--group=billing". Arguments after "--" are left untouched.
+func parseSelector(args []string) (selector, []string, error) {
+	s := selector{}
+	rest := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, value := arg, ""
+		hasValue := false
+		if n := strings.Index(arg, "="); n > 0 && strings.HasPrefix(arg, "-") {
+			name, value, hasValue = arg[:n], arg[n+1:], true
+		}
+
+		var dest *[]string
+		switch name {
+		case "--group", "-g":
+			dest = &s.Groups
+		case "--label", "-l":
+			dest = &s.Labels
+		case "--except", "-x":
+			dest = &s.Except
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return s, nil, fmt.Errorf("Error parsing options: flag needs an argument: %s", name)
+			}
+			i++
+			value = args[i]
+		}
+		*dest = append(*dest, value)
+	}
+
+	return s, rest, nil
+}
+
+// setSelector reads the selector from the arguments of the command. The
+// first positional arguments, up to keep of them or all of them when keep
+// is negative, are returned; the other ones are service names.
+func (d *Dcm) setSelector(args []string, keep int) ([]string, error) {
+	s, rest, err := parseSelector(args)
+	if err != nil {
+		return nil, err
+	}
+	if keep >= 0 && len(rest) > keep {
+		s.Services = append(s.Services, rest[keep:]...)
+		rest = rest[:keep]
+	}
+	d.Selector = s
+	return rest, nil
+}
+
+// setSubcommandSelector reads the selector of a command taking an optional
+// subcommand. The first argument is only the subcommand when it is one of
+// the given ones, otherwise it is a service name, as in `dcm run web`.
+func (d *Dcm) setSubcommandSelector(args []string, subcommands []string) ([]string, error) {
+	_, rest, err := parseSelector(args)
+	if err != nil {
+		return nil, err
+	}
+	keep := 0
+	if len(rest) > 0 && inSlice(rest[0], subcommands) {
+		keep = 1
+	}
+	return d.setSelector(args, keep)
+}
+
+// selectServices returns the services picked by the selector of the command
+func (d *Dcm) selectServices(services map[string]*Service) (map[string]*Service, error) {
+	s := d.Selector
+	if s.IsEmpty() {
+		return services, nil
+	}
+
+	groups := getGroups(services, d.Config.Settings)
+	selected := map[string]bool{}
+	if len(s.Services) == 0 && len(s.Groups) == 0 && len(s.Labels) == 0 {
+		// Only exceptions, they are taken out of all the services
+		for name := range services {
+			selected[name] = true
+		}
+	}
+
+	for _, name := range s.Services {
+		if _, ok := services[name]; !ok {
+			return nil, fmt.Errorf("Unknown service [%s]", name)
+		}
+		selected[name] = true
+	}
+	for _, group := range s.Groups {
+		members, ok := groups[group]
+		if !ok {
+			return nil, fmt.Errorf("Unknown group [%s]", group)
+		}
+		for _, name := range members {
+			if _, ok := services[name]; !ok {
+				return nil, fmt.Errorf("Group [%s] lists unknown service [%s]", group, name)
+			}
+			selected[name] = true
+		}
+	}
+	for _, label := range s.Labels {
+		for name, service := range services {
+			if hasLabel(service, label) {
+				selected[name] = true
+			}
+		}
+	}
+
+	// Services are brought up along with the services they depend on
+	deps := getDependencyGraph(services)
+	pending := []string{}
+	for name := range selected {
+		if !s.NoDeps {
+			pending = append(pending, name)
+		}
+	}
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		for _, dep := range deps[name] {
+			if !selected[dep] {
+				selected[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+
+	// Exceptions are either service or group names
+	for _, name := range s.Except {
+		if _, ok := services[name]; ok {
+			delete(selected, name)
+			continue
+		}
+		members, ok := groups[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown service or group [%s]", name)
+		}
+		for _, member := range members {
+			delete(selected, member)
+		}
+	}
+
+	result := map[string]*Service{}
+	for name := range selected {
+		result[name] = services[name]
+	}
+	return result, nil
+}
+
+// getSelectedNames returns the names of the selected services, sorted, or
+// nil when the selector is empty, so commands can be passed on to compose
+// for all the services.
+func (d *Dcm) getSelectedNames() ([]string, error) {
+	if d.Selector.IsEmpty() {
+		return nil, nil
+	}
+
+	services, err := d.Config.Services()
+	if err != nil {
+		return nil, err
+	}
+	selected, err := d.selectServices(services)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No service selected")
+	}
+
+	names := []string{}
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getGroups returns the services of each group, from both the dcm.groups
+// labels and the groups project setting.
+func getGroups(services map[string]*Service, settings Settings) map[string][]string {
+	groups := map[string][]string{}
+	for group, members := range settings.Groups {
+		groups[group] = append(groups[group], members...)
+	}
+	for name, s := range services {
+		for _, group := range s.Groups {
+			groups[group] = append(groups[group], name)
+		}
+	}
+	return groups
+}
+
+// hasLabel tells whether the service has the label, given as "key=value",
+// or as "key" to only check that the label is set.
+func hasLabel(s *Service, label string) bool {
+	labels, _ := getMapVal(s.Config, "labels").(yamlConfig)
+	parts := strings.SplitN(label, "=", 2)
+	value, ok := labels[parts[0]]
+	if !ok {
+		return false
+	}
+	return len(parts) == 1 || fmt.Sprint(value) == parts[1]
+}
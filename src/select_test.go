@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	fixtures := []struct {
+		name     string
+		args     []string
+		selector selector
+		rest     []string
+		err      error
+	}{
+		{
+			name:     "No selector",
+			args:     []string{"up"},
+			selector: selector{},
+			rest:     []string{"up"},
+		},
+		{
+			name: "Repeated options in both forms",
+			args: []string{"up", "--group", "billing", "-g=api", "--label", "tier=db", "-x", "mysql", "--except=cache"},
+			selector: selector{
+				Groups: []string{"billing", "api"},
+				Labels: []string{"tier=db"},
+				Except: []string{"mysql", "cache"},
+			},
+			rest: []string{"up"},
+		},
+		{
+			name:     "Arguments after -- are left untouched",
+			args:     []string{"execute", "--", "logs", "--group", "billing"},
+			selector: selector{},
+			rest:     []string{"execute", "--", "logs", "--group", "billing"},
+		},
+		{
+			name: "Missing value",
+			args: []string{"--group"},
+			err:  errors.New("Error parsing options: flag needs an argument: --group"),
+		},
+	}
+
+	for n, test := range fixtures {
+		s, rest, err := parseSelector(test.args)
+		if test.err != nil {
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.selector, s, "[%d: %s] Incorrect selector returned", n, test.name)
+		assert.Equal(t, test.rest, rest, "[%d: %s] Incorrect arguments returned", n, test.name)
+	}
+}
+
+func TestSelectServices(t *testing.T) {
+	config := NewConfig()
+	config.Settings = NewSettings()
+	config.Settings.Groups = map[string][]string{"ops": {"monitor"}}
+	config.Config = yamlConfig{
+		"invoices": yamlConfig{
+			"depends_on": []interface{}{"mysql"},
+			"labels":     yamlConfig{"dcm.groups": "billing"},
+		},
+		"payments": yamlConfig{
+			"depends_on": []interface{}{"invoices", "cache"},
+			"labels":     yamlConfig{"dcm.groups": "billing, api"},
+		},
+		"mysql":   yamlConfig{"labels": yamlConfig{"tier": "db"}},
+		"cache":   yamlConfig{"labels": yamlConfig{"tier": "cache"}},
+		"monitor": yamlConfig{},
+		"web":     yamlConfig{"depends_on": []interface{}{"payments"}},
+	}
+	services, err := config.Services()
+	require.Nil(t, err)
+
+	fixtures := []struct {
+		name     string
+		selector selector
+		services []string
+		err      error
+	}{
+		{
+			name:     "Empty selector",
+			selector: selector{},
+			services: []string{"cache", "invoices", "monitor", "mysql", "payments", "web"},
+		},
+		{
+			name:     "Service names with their dependencies",
+			selector: selector{Services: []string{"invoices"}},
+			services: []string{"invoices", "mysql"},
+		},
+		{
+			name:     "Service names and group without their dependencies",
+			selector: selector{Services: []string{"web"}, Groups: []string{"ops"}, NoDeps: true},
+			services: []string{"monitor", "web"},
+		},
+		{
+			name:     "Group from the labels",
+			selector: selector{Groups: []string{"billing"}},
+			services: []string{"cache", "invoices", "mysql", "payments"},
+		},
+		{
+			name:     "Group from the settings",
+			selector: selector{Groups: []string{"ops"}},
+			services: []string{"monitor"},
+		},
+		{
+			name:     "Labels with and without value",
+			selector: selector{Labels: []string{"tier=db", "dcm.groups"}},
+			services: []string{"cache", "invoices", "mysql", "payments"},
+		},
+		{
+			name:     "Exceptions are removed after the dependencies are added",
+			selector: selector{Groups: []string{"billing"}, Except: []string{"mysql", "api"}},
+			services: []string{"cache", "invoices"},
+		},
+		{
+			name:     "Only exceptions",
+			selector: selector{Except: []string{"web", "billing"}},
+			services: []string{"cache", "monitor", "mysql"},
+		},
+		{
+			name:     "Unknown service",
+			selector: selector{Services: []string{"billing"}},
+			err:      errors.New("Unknown service [billing]"),
+		},
+		{
+			name:     "Unknown group",
+			selector: selector{Groups: []string{"payments"}},
+			err:      errors.New("Unknown group [payments]"),
+		},
+		{
+			name:     "Unknown exception",
+			selector: selector{Except: []string{"nope"}},
+			err:      errors.New("Unknown service or group [nope]"),
+		},
+	}
+
+	for n, test := range fixtures {
+		dcm := NewDcm(config, []string{})
+		dcm.Selector = test.selector
+		selected, err := dcm.selectServices(services)
+		if test.err != nil {
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		names := []string{}
+		for name := range selected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, test.services, names, "[%d: %s] Incorrect services selected", n, test.name)
+	}
+
+	// Names are only returned when there is a selector
+	dcm := NewDcm(config, []string{})
+	names, err := dcm.getSelectedNames()
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+
+	dcm.Selector = selector{Services: []string{"invoices"}}
+	names, err = dcm.getSelectedNames()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"invoices", "mysql"}, names)
+
+	dcm.Selector = selector{Services: []string{"mysql"}, Except: []string{"mysql"}}
+	_, err = dcm.getSelectedNames()
+	assert.EqualError(t, err, "No service selected")
+}
+
+func TestSetSelector(t *testing.T) {
+	dcm := NewDcm(NewConfig(), []string{})
+
+	args, err := dcm.setSelector([]string{"images", "-g", "billing", "api", "web"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"images"}, args)
+	assert.Equal(t, selector{Services: []string{"api", "web"}, Groups: []string{"billing"}}, dcm.Selector)
+
+	args, err = dcm.setSelector([]string{"api", "--except", "db"}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api"}, args)
+	assert.Equal(t, selector{Except: []string{"db"}}, dcm.Selector)
+
+	_, err = dcm.setSelector([]string{"--label"}, 0)
+	assert.EqualError(t, err, "Error parsing options: flag needs an argument: --label")
+}
+
+func TestRunForSelected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mock := &CmdMock{}
+	dcm := NewDcm(NewConfig(), []string{})
+	dcm.Config.Dir = dir
+	dcm.Cmd = mock
+	dcm.Config.Config = yamlConfig{
+		"api": yamlConfig{"depends_on": []interface{}{"db"}},
+		"db":  yamlConfig{},
+		"web": yamlConfig{},
+	}
+
+	// Without selector, compose acts on all the services
+	code, err := dcm.Run("build")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--project-directory", dir, "build"}, mock.args)
+
+	dcm.Selector = selector{Services: []string{"api"}}
+	code, err = dcm.Run("build")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--project-directory", dir, "build", "api", "db"}, mock.args)
+
+	// Without a docker daemon to wait for the services
+	dcm.Config.Settings.WaitTimeout = 0
+	dcm.Selector = selector{Services: []string{"api"}, Except: []string{"db"}}
+	code, err = dcm.runUp()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--project-directory", dir, "up", "-d", "--force-recreate", "--no-deps", "api"}, mock.args)
+
+	dcm.Selector = selector{Services: []string{"missing"}}
+	code, err = dcm.Run("stop")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown service [missing]")
+}
+
+func TestCommandSelectors(t *testing.T) {
+	var out bytes.Buffer
+
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, ioutil.WriteFile(dir+"/project.yml", []byte(""+
+		"x-dcm:\n"+
+		"  groups:\n"+
+		"    billing: [invoices]\n"+
+		"invoices:\n"+
+		"  image: invoices\n"+
+		"  depends_on: [mysql]\n"+
+		"mysql:\n"+
+		"  image: mysql\n"+
+		"web:\n"+
+		"  image: web\n"), 0644))
+	os.Unsetenv("DCM_CONFIG_FILE")
+
+	fixtures := []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"list"}, "mysql\ninvoices\nweb\n"},
+		{[]string{"list", "--group", "billing"}, "mysql\ninvoices\n"},
+		{[]string{"list", "web", "invoices", "-x", "mysql"}, "invoices\nweb\n"},
+	}
+
+	for n, test := range fixtures {
+		out.Reset()
+		dcm := NewDcm(NewConfig(), append([]string{"--dir", dir, "--project", "project"}, test.args...))
+		dcm.Stdout = &out
+		code, err := dcm.Command()
+		assert.Equal(t, 0, code, "[%d: %v] Incorrect error code returned", n, test.args)
+		assert.NoError(t, err, "[%d: %v] Non-nil error returned", n, test.args)
+		assert.Equal(t, test.out, out.String(), "[%d: %v] Incorrect services listed", n, test.args)
+	}
+}
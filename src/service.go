@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type labelType int
+
+const (
+	labelString labelType = iota
+	labelBool
+	// labelAddress is a TCP address or an HTTP(S) URL
+	labelAddress
+	// labelTarget is where a script runs, either host or container
+	labelTarget
+)
+
+// dcmLabels is the schema of all the labels supported by DCM
+var dcmLabels = map[string]labelType{
+	"dcm.repository":           labelString,
+	"dcm.branch":               labelString,
+	"dcm.initscript":           labelString,
+	"dcm.pre_initscript":       labelString,
+	"dcm.initscript_shell":     labelString,
+	"dcm.initscript_target":    labelTarget,
+	"dcm.initscript_user":      labelString,
+	"dcm.initscript_workdir":   labelString,
+	"dcm.container_initscript": labelString,
+	"dcm.updateable":           labelBool,
+	"dcm.groups":               labelString,
+	"dcm.dockerfile":           labelString,
+	"dcm.build_target":         labelString,
+	"dcm.wait_for":             labelAddress,
+}
+
+// Where the init scripts run: on the host from the folder of the service,
+// or in its running container
+const (
+	initTargetHost      = "host"
+	initTargetContainer = "container"
+)
+
+// Sources of the images of the services
+const (
+	sourceRepo  = "repo"
+	sourceBuild = "build"
+	sourceImage = "image"
+)
+
+type Service struct {
+	Name                string     `yaml:"-" json:"-"`
+	Image               string     `yaml:"image,omitempty" json:"image,omitempty"`
+	Build               string     `yaml:"build,omitempty" json:"build,omitempty"`
+	Repository          string     `yaml:"repository,omitempty" json:"repository,omitempty"`
+	Branch              string     `yaml:"branch,omitempty" json:"branch,omitempty"`
+	InitScript          string     `yaml:"initscript,omitempty" json:"initscript,omitempty"`
+	PreInitScript       string     `yaml:"pre_initscript,omitempty" json:"pre_initscript,omitempty"`
+	InitShell           string     `yaml:"initscript_shell" json:"initscript_shell"`
+	InitTarget          string     `yaml:"initscript_target" json:"initscript_target"`
+	InitUser            string     `yaml:"initscript_user,omitempty" json:"initscript_user,omitempty"`
+	InitWorkdir         string     `yaml:"initscript_workdir,omitempty" json:"initscript_workdir,omitempty"`
+	ContainerInitScript string     `yaml:"container_initscript,omitempty" json:"container_initscript,omitempty"`
+	Updateable          bool       `yaml:"updateable" json:"updateable"`
+	Groups              []string   `yaml:"groups,omitempty" json:"groups,omitempty"`
+	WaitFor             string     `yaml:"wait_for,omitempty" json:"wait_for,omitempty"`
+	DependsOn           []string   `yaml:"depends_on" json:"depends_on"`
+	Config              yamlConfig `yaml:"config" json:"config"`
+}
+
+// NewService decodes the configs of a service, using the project settings
+// as defaults for the labels that are not set.
+func NewService(name string, configs yamlConfig, settings Settings) (*Service, error) {
+	s := &Service{
+		Name:       name,
+		InitShell:  settings.InitShell,
+		InitTarget: initTargetHost,
+		Updateable: true,
+		DependsOn:  getServiceDependencies(configs),
+		Config:     configs,
+	}
+
+	var ok bool
+	if image, exists := configs["image"]; exists {
+		if s.Image, ok = image.(string); !ok {
+			return nil, fmt.Errorf("Error reading configs for service [%s]: image must be a string", name)
+		}
+	}
+
+	// build can be either the path of the build context, or a map with
+	// the path under the context key
+	switch build := configs["build"].(type) {
+	case string:
+		s.Build = build
+	case yamlConfig:
+		s.Build, _ = build["context"].(string)
+	}
+
+	var groups string
+	labels, _ := getMapVal(configs, "labels").(yamlConfig)
+	for _, label := range []struct {
+		name string
+		dest interface{}
+	}{
+		{"dcm.repository", &s.Repository},
+		{"dcm.branch", &s.Branch},
+		{"dcm.initscript", &s.InitScript},
+		{"dcm.pre_initscript", &s.PreInitScript},
+		{"dcm.initscript_shell", &s.InitShell},
+		{"dcm.initscript_target", &s.InitTarget},
+		{"dcm.initscript_user", &s.InitUser},
+		{"dcm.initscript_workdir", &s.InitWorkdir},
+		{"dcm.container_initscript", &s.ContainerInitScript},
+		{"dcm.updateable", &s.Updateable},
+		{"dcm.groups", &groups},
+		{"dcm.wait_for", &s.WaitFor},
+	} {
+		value, err := getLabelValue(labels, label.name)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading configs for service [%s]: %v", name, err)
+		}
+		switch value := value.(type) {
+		case string:
+			*label.dest.(*string) = value
+		case bool:
+			*label.dest.(*bool) = value
+		}
+	}
+
+	// Groups are given as a comma separated list, e.g. "billing, api"
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			s.Groups = append(s.Groups, group)
+		}
+	}
+
+	return s, nil
+}
+
+// Source tells where the image of the service comes from: built from the
+// checkout of its repo, built from another local folder, or pulled.
+func (s *Service) Source() string {
+	switch {
+	case s.BuildsFromCheckout():
+		return sourceRepo
+	case s.Build != "":
+		return sourceBuild
+	}
+	return sourceImage
+}
+
+// BuildsFromCheckout tells whether the image is built from the checkout of
+// the service repo, the same way setImplicitBuilds decides it: a repo with
+// no image, or a build, given or implied by dcm.dockerfile or
+// dcm.build_target. The image of a service with a repo is pulled otherwise.
+func (s *Service) BuildsFromCheckout() bool {
+	return s.Repository != "" && (s.Image == "" || s.Build != "")
+}
+
+// getLabelValue reads a dcm label and coerces it to the type from the
+// schema. It returns nil when the label is not set.
+func getLabelValue(labels yamlConfig, name string) (interface{}, error) {
+	value, ok := labels[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch dcmLabels[name] {
+	case labelBool:
+		switch value := value.(type) {
+		case bool:
+			return value, nil
+		case string:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("Label [%s] must be either true or false, got [%v]", name, value)
+	case labelAddress:
+		if value, ok := value.(string); ok {
+			if _, err := parseWaitFor(value); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("Label [%s] must be either host:port or an http(s) URL, got [%v]", name, value)
+	case labelTarget:
+		if value == initTargetHost || value == initTargetContainer {
+			return value, nil
+		}
+		return nil, fmt.Errorf("Label [%s] must be either %s or %s, got [%v]", name, initTargetHost, initTargetContainer, value)
+	default:
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case int, int64, uint64:
+			return fmt.Sprint(value), nil
+		case float64, bool:
+			// YAML turns unquoted values like `1.10` into `1.1`, which
+			// can't be told apart from `1.1`, so they have to be quoted
+			return nil, fmt.Errorf("Label [%s] must be a string, put the value in quotes, got [%v]", name, value)
+		}
+		return nil, fmt.Errorf("Label [%s] must be a string, got [%v]", name, value)
+	}
+}
+
+func getServices(config yamlConfig, settings Settings) (map[string]*Service, error) {
+	services := map[string]*Service{}
+
+	names := []string{}
+	for key := range config {
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid service name: %v", key)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		configs, ok := config[name].(yamlConfig)
+		if !ok {
+			return nil, fmt.Errorf("Error reading configs for service: %s", name)
+		}
+		s, err := NewService(name, configs, settings)
+		if err != nil {
+			return nil, err
+		}
+		services[name] = s
+	}
+
+	return services, nil
+}
+
+func (c *Config) Services() (map[string]*Service, error) {
+	return getServices(c.Config, c.Settings)
+}
+
+func (c *Config) Service(name string) (*Service, error) {
+	configs, ok := getMapVal(c.Config, name).(yamlConfig)
+	if !ok {
+		return nil, errors.New("Service not exists.")
+	}
+	return NewService(name, configs, c.Settings)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewService(t *testing.T) {
+	fixtures := []struct {
+		name    string
+		configs yamlConfig
+		service *Service
+		err     error
+	}{
+		{
+			name:    "Positive case: defaults",
+			configs: yamlConfig{},
+			service: &Service{
+				Name:       "service",
+				InitShell:  "/bin/bash",
+				InitTarget: "host",
+				Updateable: true,
+				DependsOn:  []string{},
+				Config:     yamlConfig{},
+			},
+		},
+		{
+			name: "Positive case: docker hub image",
+			configs: yamlConfig{
+				"image":      "docker-hub-image",
+				"depends_on": []interface{}{"db"},
+				"labels": yamlConfig{
+					"dcm.updateable": false,
+				},
+			},
+			service: &Service{
+				Name:       "service",
+				Image:      "docker-hub-image",
+				InitShell:  "/bin/bash",
+				InitTarget: "host",
+				Updateable: false,
+				DependsOn:  []string{"db"},
+			},
+		},
+		{
+			name: "Positive case: local build",
+			configs: yamlConfig{
+				"build": yamlConfig{"context": "./srv/project/service"},
+				"labels": yamlConfig{
+					"dcm.repository":       "git@github.com:username/repository.git",
+					"dcm.branch":           1.5,
+					"dcm.initscript":       "dcm/init.bash",
+					"dcm.pre_initscript":   "dcm/pre-init.bash",
+					"dcm.initscript_shell": "/bin/sh",
+					"dcm.updateable":       "False",
+					"dcm.groups":           "billing, ,api",
+				},
+			},
+			service: &Service{
+				Name:          "service",
+				Build:         "./srv/project/service",
+				Repository:    "git@github.com:username/repository.git",
+				Branch:        "1.5",
+				InitScript:    "dcm/init.bash",
+				PreInitScript: "dcm/pre-init.bash",
+				InitShell:     "/bin/sh",
+				InitTarget:    "host",
+				Updateable:    false,
+				Groups:        []string{"billing", "api"},
+				DependsOn:     []string{},
+			},
+		},
+		{
+			name: "Positive case: init script in the container",
+			configs: yamlConfig{
+				"image": "username/api",
+				"labels": yamlConfig{
+					"dcm.initscript":           "bin/init.sh",
+					"dcm.initscript_target":    "container",
+					"dcm.initscript_user":      "www-data",
+					"dcm.initscript_workdir":   "/app",
+					"dcm.container_initscript": "/app/bin/seed.sh",
+				},
+			},
+			service: &Service{
+				Name:                "service",
+				Image:               "username/api",
+				InitScript:          "bin/init.sh",
+				InitShell:           "/bin/bash",
+				InitTarget:          "container",
+				InitUser:            "www-data",
+				InitWorkdir:         "/app",
+				ContainerInitScript: "/app/bin/seed.sh",
+				Updateable:          true,
+				DependsOn:           []string{},
+			},
+		},
+		{
+			name:    "Negative case: image is not a string",
+			configs: yamlConfig{"image": []interface{}{"docker-hub-image"}},
+			err:     errors.New("Error reading configs for service [service]: image must be a string"),
+		},
+		{
+			name: "Negative case: invalid bool label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.updateable": "nope"},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.updateable] must be either true or false, got [nope]"),
+		},
+		{
+			name: "Negative case: invalid string label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.repository": true},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.repository] must be a string, put the value in quotes, got [true]"),
+		},
+		{
+			name: "Negative case: unquoted decimal label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.branch": 1.10},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.branch] must be a string, put the value in quotes, got [1.1]"),
+		},
+		{
+			name: "Negative case: list label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.branch": []interface{}{"master"}},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.branch] must be a string, got [[master]]"),
+		},
+		{
+			name: "Negative case: invalid address label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.wait_for": "ftp://db:21"},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.wait_for] must be either host:port or an http(s) URL, got [ftp://db:21]"),
+		},
+		{
+			name: "Negative case: invalid target label",
+			configs: yamlConfig{
+				"labels": yamlConfig{"dcm.initscript_target": "vm"},
+			},
+			err: errors.New("Error reading configs for service [service]: Label [dcm.initscript_target] must be either host or container, got [vm]"),
+		},
+	}
+
+	for n, test := range fixtures {
+		s, err := NewService("service", test.configs, NewSettings())
+		if test.err != nil {
+			assert.Nil(t, s, "[%d: %s] Non-nil service returned", n, test.name)
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		test.service.Config = test.configs
+		assert.Equal(t, test.service, s, "[%d: %s] Incorrect service returned", n, test.name)
+	}
+	// The project settings are the defaults, labels still override them
+	settings := NewSettings()
+	settings.InitShell = "/bin/sh"
+	s, err := NewService("service", yamlConfig{}, settings)
+	assert.NoError(t, err)
+	assert.Equal(t, "/bin/sh", s.InitShell)
+	s, err = NewService("service", yamlConfig{
+		"labels": yamlConfig{"dcm.initscript_shell": "/bin/zsh"},
+	}, settings)
+	assert.NoError(t, err)
+	assert.Equal(t, "/bin/zsh", s.InitShell)
+}
+
+func TestGetLabelValue(t *testing.T) {
+	labels := yamlConfig{
+		"dcm.branch":     "develop",
+		"dcm.initscript": nil,
+		"dcm.updateable": "true",
+		"dcm.wait_for":   "localhost:5432",
+	}
+
+	value, err := getLabelValue(labels, "dcm.branch")
+	assert.Equal(t, "develop", value)
+	assert.NoError(t, err)
+
+	value, err = getLabelValue(labels, "dcm.initscript")
+	assert.Nil(t, value)
+	assert.NoError(t, err)
+
+	value, err = getLabelValue(labels, "dcm.repository")
+	assert.Nil(t, value)
+	assert.NoError(t, err)
+
+	value, err = getLabelValue(labels, "dcm.updateable")
+	assert.Equal(t, true, value)
+	assert.NoError(t, err)
+
+	value, err = getLabelValue(labels, "dcm.wait_for")
+	assert.Equal(t, "localhost:5432", value)
+	assert.NoError(t, err)
+}
+
+func TestGetServices(t *testing.T) {
+	services, err := getServices(yamlConfig{
+		"api": yamlConfig{"image": "api"},
+		"web": yamlConfig{"image": "web"},
+	}, NewSettings())
+	assert.NoError(t, err)
+	assert.Len(t, services, 2)
+	assert.Equal(t, "api", services["api"].Name)
+	assert.Equal(t, "web", services["web"].Image)
+
+	_, err = getServices(yamlConfig{1: yamlConfig{}}, NewSettings())
+	assert.EqualError(t, err, "Invalid service name: 1")
+
+	_, err = getServices(yamlConfig{"api": "config"}, NewSettings())
+	assert.EqualError(t, err, "Error reading configs for service: api")
+
+	_, err = getServices(yamlConfig{"api": yamlConfig{"image": 1}}, NewSettings())
+	assert.EqualError(t, err, "Error reading configs for service [api]: image must be a string")
+}
+
+func TestConfigService(t *testing.T) {
+	c := NewConfig()
+	c.Config = yamlConfig{"api": yamlConfig{"image": "api"}}
+
+	s, err := c.Service("api")
+	assert.NoError(t, err)
+	assert.Equal(t, "api", s.Image)
+
+	s, err = c.Service("invalid")
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "Service not exists.")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of DCM, checked against the required_version setting. Builds
+// from the Makefile set it from the git tags, with -ldflags "-X main.Version=..."
+var Version = "1.0.0-dev"
+
+// Settings are the project wide defaults read from the top level x-dcm
+// block of the config file. Service labels take precedence over them.
+type Settings struct {
+	DefaultBranch   string `yaml:"default_branch" json:"default_branch"`
+	InitShell       string `yaml:"init_shell" json:"init_shell"`
+	Srv             string `yaml:"srv,omitempty" json:"srv,omitempty"`
+	ComposeBinary   string `yaml:"compose_binary" json:"compose_binary"`
+	Jobs            int    `yaml:"jobs" json:"jobs"`
+	RequiredVersion string `yaml:"required_version,omitempty" json:"required_version,omitempty"`
+
+	// WaitTimeout is how long, in seconds, `dcm run up` waits for each
+	// service to be ready before running the init scripts
+	WaitTimeout int `yaml:"wait_timeout" json:"wait_timeout"`
+
+	// PortOffset moves the host ports published by the services
+	PortOffset int `yaml:"port_offset,omitempty" json:"port_offset,omitempty"`
+
+	// Groups lists the services of each group, on top of the ones given
+	// by the dcm.groups labels
+	Groups map[string][]string `yaml:"groups,omitempty" json:"groups,omitempty"`
+}
+
+func NewSettings() Settings {
+	return Settings{
+		DefaultBranch: "master",
+		InitShell:     "/bin/bash",
+		ComposeBinary: composeAuto,
+		Jobs:          1,
+		WaitTimeout:   120,
+	}
+}
+
+// loadSettings reads the x-dcm block on top of the defaults, and applies
+// the settings that change the rest of the config.
+func (c *Config) loadSettings(block interface{}) error {
+	c.Settings = NewSettings()
+	if block == nil {
+		return nil
+	}
+
+	settings, ok := block.(yamlConfig)
+	if !ok {
+		return fmt.Errorf("Error reading x-dcm settings: x-dcm must be a mapping")
+	}
+
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := c.Settings.set(key, settings[key]); err != nil {
+			return fmt.Errorf("Error reading x-dcm settings: %v", err)
+		}
+	}
+
+	if c.Settings.PortOffset == autoPortOffset {
+		c.Settings.PortOffset = getAutoPortOffset(c.Project)
+	}
+
+	// The srv directory given on the command line or by the environment
+	// takes precedence over the setting
+	if c.Settings.Srv != "" && c.isDefault("srv") {
+		c.Srv = c.Settings.Srv
+		if !filepath.IsAbs(c.Srv) {
+			c.Srv = filepath.Join(c.Dir, c.Srv)
+		}
+		c.setOrigin("srv", "x-dcm")
+	}
+
+	return checkVersion(c.Settings.RequiredVersion, Version)
+}
+
+func (s *Settings) set(key string, value interface{}) error {
+	if key == "groups" {
+		return s.setGroups(value)
+	}
+	if key == "port_offset" {
+		offset, ok := value.(int)
+		switch {
+		case value == "auto":
+			s.PortOffset = autoPortOffset
+		case !ok || offset < 0:
+			return fmt.Errorf("Setting [port_offset] must be either a positive number or auto, got [%v]", value)
+		default:
+			s.PortOffset = offset
+		}
+		return nil
+	}
+	if key == "wait_timeout" {
+		timeout, ok := value.(int)
+		if !ok || timeout < 0 {
+			return fmt.Errorf("Setting [wait_timeout] must be a number of seconds, got [%v]", value)
+		}
+		s.WaitTimeout = timeout
+		return nil
+	}
+	if key == "jobs" {
+		jobs, ok := value.(int)
+		if !ok || jobs < 1 {
+			return fmt.Errorf("Setting [jobs] must be a positive number, got [%v]", value)
+		}
+		s.Jobs = jobs
+		return nil
+	}
+
+	dest, ok := map[string]*string{
+		"default_branch":   &s.DefaultBranch,
+		"init_shell":       &s.InitShell,
+		"srv":              &s.Srv,
+		"compose_binary":   &s.ComposeBinary,
+		"required_version": &s.RequiredVersion,
+	}[key]
+	if !ok {
+		return fmt.Errorf("Unknown setting [%s]", key)
+	}
+
+	switch value := value.(type) {
+	case string:
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("Setting [%s] must not be empty", key)
+		}
+		*dest = value
+	case int, int64, uint64:
+		*dest = fmt.Sprint(value)
+	case float64, bool:
+		// YAML turns unquoted values like `1.10` into `1.1`, which
+		// can't be told apart from `1.1`, so they have to be quoted
+		return fmt.Errorf("Setting [%s] must be a string, put the value in quotes, got [%v]", key, value)
+	default:
+		return fmt.Errorf("Setting [%s] must be a string, got [%v]", key, value)
+	}
+	return nil
+}
+
+// setGroups reads the groups setting, a mapping of group names to lists of
+// service names.
+func (s *Settings) setGroups(value interface{}) error {
+	groups, ok := value.(yamlConfig)
+	if !ok {
+		return fmt.Errorf("Setting [groups] must map group names to lists of services, got [%v]", value)
+	}
+
+	s.Groups = map[string][]string{}
+	for group, members := range groups {
+		list, ok := members.([]interface{})
+		if !ok {
+			return fmt.Errorf("Group [%v] must be a list of services, got [%v]", group, members)
+		}
+		names := []string{}
+		for _, member := range list {
+			name, ok := member.(string)
+			if !ok {
+				return fmt.Errorf("Group [%v] must be a list of services, got [%v]", group, members)
+			}
+			names = append(names, name)
+		}
+		s.Groups[fmt.Sprint(group)] = names
+	}
+	return nil
+}
+
+// checkVersion tells whether the version satisfies the constraint, a comma
+// separated list of comparisons like ">=1.2, <2". A version without an
+// operator is the minimum version required.
+func checkVersion(constraint, version string) error {
+	if constraint == "" {
+		return nil
+	}
+
+	for _, part := range strings.Split(constraint, ",") {
+		part = strings.TrimSpace(part)
+		required := strings.TrimLeft(part, "<>=!")
+		operator := part[:len(part)-len(required)]
+		required = strings.TrimSpace(required)
+
+		cmp, err := compareVersions(version, required)
+		if err != nil {
+			return fmt.Errorf("Invalid required version [%s]: %v", constraint, err)
+		}
+
+		var ok bool
+		switch operator {
+		case "", ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			return fmt.Errorf("Invalid required version [%s]: unknown operator [%s]", constraint, operator)
+		}
+		if !ok {
+			return fmt.Errorf(
+				"This project requires DCM version %s, current version is %s",
+				constraint, version,
+			)
+		}
+	}
+
+	return nil
+}
+
+// compareVersions compares two dotted version numbers, returning -1, 0 or 1.
+// Missing parts count as zero and pre-release suffixes are ignored.
+func compareVersions(a, b string) (int, error) {
+	pa, err := getVersionParts(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := getVersionParts(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for len(pa) < len(pb) {
+		pa = append(pa, 0)
+	}
+	for len(pb) < len(pa) {
+		pb = append(pb, 0)
+	}
+
+	for i := range pa {
+		switch {
+		case pa[i] < pb[i]:
+			return -1, nil
+		case pa[i] > pb[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func getVersionParts(version string) ([]int, error) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+
+	parts := []int{}
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("[%s] is not a version number", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var yamlFixtureSettings string = `
+version: "2"
+x-dcm:
+  default_branch: develop
+  init_shell: /bin/sh
+  srv: ./checkouts
+  compose_binary: docker compose
+  jobs: 4
+  required_version: ">=0.1, <100"
+services:
+  api:
+    build: ./checkouts/api
+    labels:
+      dcm.repository: git@github.com:username/api.git
+  web:
+    build: ./checkouts/web
+    labels:
+      dcm.repository: git@github.com:username/web.git
+      dcm.initscript_shell: /bin/bash
+`
+
+func TestCreateNewConfigFileWithSettings(t *testing.T) {
+	os.Setenv("DCM_DIR", "/test/dcm/dir")
+	defer os.Unsetenv("DCM_DIR")
+	file := helperCreateTestFile(t, "good_yaml_settings", yamlFixtureSettings)
+	defer os.Remove(file)
+	os.Setenv("DCM_CONFIG_FILE", file)
+
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+	assert.Equal(t, Settings{
+		DefaultBranch:   "develop",
+		InitShell:       "/bin/sh",
+		Srv:             "./checkouts",
+		ComposeBinary:   "docker compose",
+		Jobs:            4,
+		RequiredVersion: ">=0.1, <100",
+		WaitTimeout:     120,
+	}, config.Settings)
+	assert.Equal(t, "/test/dcm/dir/checkouts", config.Srv)
+	assert.Equal(t, 4, NewDcm(config, []string{}).Jobs)
+
+	// The x-dcm block is never a service
+	services, err := config.Services()
+	require.Nil(t, err)
+	assert.Len(t, services, 2)
+	assert.Equal(t, "/bin/sh", services["api"].InitShell)
+	assert.Equal(t, "/bin/bash", services["web"].InitShell)
+}
+
+func TestLoadSettings(t *testing.T) {
+	fixtures := []struct {
+		name  string
+		block interface{}
+		srv   string
+		err   error
+	}{
+		{
+			name: "Positive case: no x-dcm block",
+			srv:  "/test/dcm/dir/srv/dcm",
+		},
+		{
+			name:  "Positive case: absolute srv directory",
+			block: yamlConfig{"srv": "/var/srv", "default_branch": 1.5},
+			srv:   "/var/srv",
+		},
+		{
+			name:  "Negative case: x-dcm is not a mapping",
+			block: []interface{}{"jobs"},
+			err:   errors.New("Error reading x-dcm settings: x-dcm must be a mapping"),
+		},
+		{
+			name:  "Negative case: unknown setting",
+			block: yamlConfig{"default_brnach": "develop"},
+			err:   errors.New("Error reading x-dcm settings: Unknown setting [default_brnach]"),
+		},
+		{
+			name:  "Negative case: invalid jobs",
+			block: yamlConfig{"jobs": "many"},
+			err:   errors.New("Error reading x-dcm settings: Setting [jobs] must be a positive number, got [many]"),
+		},
+		{
+			name:  "Negative case: invalid wait timeout",
+			block: yamlConfig{"wait_timeout": "2m"},
+			err:   errors.New("Error reading x-dcm settings: Setting [wait_timeout] must be a number of seconds, got [2m]"),
+		},
+		{
+			name:  "Negative case: invalid port offset",
+			block: yamlConfig{"port_offset": -100},
+			err:   errors.New("Error reading x-dcm settings: Setting [port_offset] must be either a positive number or auto, got [-100]"),
+		},
+		{
+			name:  "Negative case: empty setting",
+			block: yamlConfig{"compose_binary": " "},
+			err:   errors.New("Error reading x-dcm settings: Setting [compose_binary] must not be empty"),
+		},
+		{
+			name:  "Negative case: invalid string setting",
+			block: yamlConfig{"init_shell": []interface{}{"/bin/sh"}},
+			err:   errors.New("Error reading x-dcm settings: Setting [init_shell] must be a string, got [[/bin/sh]]"),
+		},
+		{
+			name:  "Negative case: unquoted decimal setting",
+			block: yamlConfig{"required_version": 1.10},
+			err:   errors.New("Error reading x-dcm settings: Setting [required_version] must be a string, put the value in quotes, got [1.1]"),
+		},
+		{
+			name:  "Negative case: groups is not a mapping",
+			block: yamlConfig{"groups": []interface{}{"billing"}},
+			err:   errors.New("Error reading x-dcm settings: Setting [groups] must map group names to lists of services, got [[billing]]"),
+		},
+		{
+			name:  "Negative case: group is not a list of services",
+			block: yamlConfig{"groups": yamlConfig{"billing": "api"}},
+			err:   errors.New("Error reading x-dcm settings: Group [billing] must be a list of services, got [api]"),
+		},
+		{
+			name:  "Negative case: required version not satisfied",
+			block: yamlConfig{"required_version": "100"},
+			err:   errors.New("This project requires DCM version 100, current version is " + Version),
+		},
+	}
+
+	for n, test := range fixtures {
+		c := &Config{Dir: "/test/dcm/dir", Srv: "/test/dcm/dir/srv/dcm"}
+		err := c.loadSettings(test.block)
+		if test.err != nil {
+			assert.EqualError(t, err, test.err.Error(), "[%d: %s] Incorrect error returned", n, test.name)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		assert.Equal(t, test.srv, c.Srv, "[%d: %s] Incorrect srv directory", n, test.name)
+	}
+
+	c := &Config{}
+	require.Nil(t, c.loadSettings(yamlConfig{
+		"groups": yamlConfig{"billing": []interface{}{"api", "db"}},
+	}))
+	assert.Equal(t, map[string][]string{"billing": {"api", "db"}}, c.Settings.Groups)
+
+	// The port offset is either given or derived from the project name
+	c = &Config{Project: "instance3"}
+	require.Nil(t, c.loadSettings(yamlConfig{"port_offset": 1000}))
+	assert.Equal(t, 1000, c.Settings.PortOffset)
+	require.Nil(t, c.loadSettings(yamlConfig{"port_offset": "auto"}))
+	assert.Equal(t, 300, c.Settings.PortOffset)
+}
+
+func TestCheckVersion(t *testing.T) {
+	fixtures := []struct {
+		constraint, version string
+		err                 string
+	}{
+		{"", "1.0.0", ""},
+		{"1.0", "1.0.0", ""},
+		{">=1.2", "1.10.0", ""},
+		{">1.0, <2", "1.5.3", ""},
+		{"<=1.0.0", "1.0.0-dev", ""},
+		{"==1", "1.0.0", ""},
+		{"!=1.0.1", "1.0.0", ""},
+		{"1.2", "1.1.9", "This project requires DCM version 1.2, current version is 1.1.9"},
+		{">1.0, <2", "2.0.0", "This project requires DCM version >1.0, <2, current version is 2.0.0"},
+		{"~1.0", "1.0.0", "Invalid required version [~1.0]: [~1.0] is not a version number"},
+		{"=>1.0", "1.0.0", "Invalid required version [=>1.0]: unknown operator [=>]"},
+	}
+
+	for n, test := range fixtures {
+		err := checkVersion(test.constraint, test.version)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.constraint)
+		} else {
+			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.constraint)
+		}
+	}
+}
+
+func TestRequiredVersion(t *testing.T) {
+	version := Version
+	defer func() { Version = version }()
+	// As set by the Makefile from git describe, three commits after v1.2.0
+	Version = "v1.2.0-3-g1a2b3c4"
+
+	c := &Config{Dir: "/test/dcm/dir", Srv: "/test/dcm/dir/srv/dcm"}
+	assert.NoError(t, c.loadSettings(yamlConfig{"required_version": ">=1.2, <2"}))
+	assert.EqualError(t,
+		c.loadSettings(yamlConfig{"required_version": ">=1.3"}),
+		"This project requires DCM version >=1.3, current version is v1.2.0-3-g1a2b3c4",
+	)
+}
+
+func TestCompareVersions(t *testing.T) {
+	fixtures := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1", "1.0.0", 0},
+		{"v1.2", "1.10", -1},
+		{"2.0.0-rc1", "1.99", 1},
+	}
+
+	for n, test := range fixtures {
+		cmp, err := compareVersions(test.a, test.b)
+		assert.NoError(t, err, "[%d] Non-nil error returned", n)
+		assert.Equal(t, test.cmp, cmp, "[%d] %s compared to %s", n, test.a, test.b)
+	}
+
+	_, err := compareVersions("1.x", "1.0")
+	assert.EqualError(t, err, "[1.x] is not a version number")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceStatus is the state of a service, as shown by `dcm status`
+type serviceStatus struct {
+	Name       string            `yaml:"name" json:"name"`
+	Source     string            `yaml:"source" json:"source"`
+	Image      string            `yaml:"image,omitempty" json:"image,omitempty"`
+	Repository string            `yaml:"repository,omitempty" json:"repository,omitempty"`
+	Git        *gitStatus        `yaml:"git,omitempty" json:"git,omitempty"`
+	Containers []containerStatus `yaml:"containers" json:"containers"`
+}
+
+// gitStatus is the state of the checkout of a service. Branch is the one
+// configured, CurrentBranch the one checked out.
+type gitStatus struct {
+	CheckedOut    bool   `yaml:"checked_out" json:"checked_out"`
+	Branch        string `yaml:"branch" json:"branch"`
+	CurrentBranch string `yaml:"current_branch,omitempty" json:"current_branch,omitempty"`
+	Upstream      string `yaml:"upstream,omitempty" json:"upstream,omitempty"`
+	Dirty         int    `yaml:"dirty" json:"dirty"`
+	Ahead         int    `yaml:"ahead" json:"ahead"`
+	Behind        int    `yaml:"behind" json:"behind"`
+	LastCommit    string `yaml:"last_commit,omitempty" json:"last_commit,omitempty"`
+	Error         string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// containerStatus is the state of a replica of a service
+type containerStatus struct {
+	Name      string   `yaml:"name" json:"name"`
+	Index     int      `yaml:"index" json:"index"`
+	State     string   `yaml:"state" json:"state"`
+	Health    string   `yaml:"health,omitempty" json:"health,omitempty"`
+	StartedAt string   `yaml:"started_at,omitempty" json:"started_at,omitempty"`
+	Uptime    string   `yaml:"uptime,omitempty" json:"uptime,omitempty"`
+	Ports     []string `yaml:"ports" json:"ports"`
+}
+
+func (d *Dcm) Status(args ...string) (int, error) {
+	output, args, err := parseOutputOption(args, outputTable)
+	if err != nil {
+		return 1, err
+	}
+	if err := checkOutputFormat(output, outputTable, outputJSON, outputYAML); err != nil {
+		return 1, err
+	}
+	if _, err := d.setSelector(args, 0); err != nil {
+		return 1, err
+	}
+
+	services, err := d.Config.Services()
+	if err != nil {
+		return 1, err
+	}
+	if services, err = d.selectServices(services); err != nil {
+		return 1, err
+	}
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := []serviceStatus{}
+	for _, name := range names {
+		status, err := d.getServiceStatus(services[name])
+		if err != nil {
+			return 1, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	if output == outputTable {
+		err = writeStatusTable(d.Stdout, statuses)
+	} else {
+		err = writeOutput(d.Stdout, output, statuses)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// getServiceStatus gathers the state of the checkout and of the containers
+// of a service. Failing git commands are reported in the status, while
+// failing to reach the docker daemon is an error.
+func (d *Dcm) getServiceStatus(s *Service) (serviceStatus, error) {
+	status := serviceStatus{
+		Name:       s.Name,
+		Image:      s.Image,
+		Repository: s.Repository,
+		Source:     s.Source(),
+		Containers: []containerStatus{},
+	}
+	if status.Source == sourceRepo {
+		status.Git = d.getGitStatus(s)
+	}
+
+	containers, err := d.getContainers(s.Name)
+	if err != nil {
+		return status, fmt.Errorf("Error getting containers for service [%s]: %v", s.Name, err)
+	}
+	engine, err := d.getEngine()
+	if err != nil {
+		return status, err
+	}
+	for _, c := range containers {
+		cs := containerStatus{Name: c.Name, Index: c.Index, State: c.State, Ports: c.Ports}
+		if c.Running {
+			state, err := engine.InspectContainer(c.ID)
+			if err != nil {
+				return status, fmt.Errorf("Error inspecting container [%s] for service [%s]: %v", c.Name, s.Name, err)
+			}
+			if state.Health != nil {
+				cs.Health = state.Health.Status
+			}
+			cs.StartedAt = state.StartedAt.Format(time.RFC3339)
+			cs.Uptime = formatUptime(time.Since(state.StartedAt))
+		}
+		status.Containers = append(status.Containers, cs)
+	}
+	return status, nil
+}
+
+// getGitStatus reads the state of the checkout of a service with git
+func (d *Dcm) getGitStatus(s *Service) *gitStatus {
+	g := &gitStatus{Branch: s.Branch}
+	if g.Branch == "" {
+		g.Branch = d.Config.Settings.DefaultBranch
+	}
+	dir := d.Config.Srv + "/" + s.Name
+	if _, err := os.Stat(dir); err != nil {
+		return g
+	}
+	g.CheckedOut = true
+
+	git := func(args ...string) (string, error) {
+		out, err := d.Cmd.Exec("git", args...).Setdir(dir).Out()
+		if err != nil {
+			return "", d.Cmd.FormatError(err, out)
+		}
+		return d.Cmd.FormatOutput(out), nil
+	}
+
+	var err error
+	if g.CurrentBranch, err = git("rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+		g.Error = err.Error()
+		return g
+	}
+	changes, err := git("status", "--porcelain")
+	if err != nil {
+		g.Error = err.Error()
+		return g
+	}
+	if changes != "" {
+		g.Dirty = len(strings.Split(changes, "\n"))
+	}
+	// Without upstream, there is nothing to be ahead of or behind
+	if upstream, err := git("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}"); err == nil {
+		g.Upstream = upstream
+		counts, err := git("rev-list", "--left-right", "--count", "HEAD..."+upstream)
+		if err != nil {
+			g.Error = err.Error()
+			return g
+		}
+		if fields := strings.Fields(counts); len(fields) == 2 {
+			g.Ahead, _ = strconv.Atoi(fields[0])
+			g.Behind, _ = strconv.Atoi(fields[1])
+		}
+	}
+	// A repo without commits has no last commit
+	g.LastCommit, _ = git("log", "-1", "--format=%h %s")
+	return g
+}
+
+// formatUptime rounds the time a container has been up for to its two
+// largest units
+func formatUptime(up time.Duration) string {
+	switch {
+	case up < time.Minute:
+		return fmt.Sprintf("%ds", int(up.Seconds()))
+	case up < time.Hour:
+		return fmt.Sprintf("%dm", int(up.Minutes()))
+	case up < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(up.Hours()), int(up.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%dh", int(up.Hours())/24, int(up.Hours())%24)
+	}
+}
+
+// writeStatusTable prints one row for each service, followed by the git
+// errors met
+func writeStatusTable(w io.Writer, statuses []serviceStatus) error {
+	rows := [][]string{}
+	for _, s := range statuses {
+		rows = append(rows, []string{
+			s.Name,
+			s.Source,
+			s.branchColumn(),
+			s.changesColumn(),
+			s.lastCommitColumn(),
+			s.stateColumn(),
+			s.firstRunning().Health,
+			s.firstRunning().Uptime,
+			strings.Join(s.ports(), ", "),
+		})
+	}
+	header := []string{"SERVICE", "SOURCE", "BRANCH", "CHANGES", "LAST COMMIT", "STATE", "HEALTH", "UPTIME", "PORTS"}
+	if err := writeTable(w, header, rows); err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.Git != nil && s.Git.Error != "" {
+			fmt.Fprintf(w, "service [%s]: %s\n", s.Name, s.Git.Error)
+		}
+	}
+	return nil
+}
+
+func (s serviceStatus) branchColumn() string {
+	switch {
+	case s.Git == nil:
+		return ""
+	case !s.Git.CheckedOut:
+		return "not checked out"
+	case s.Git.CurrentBranch == "":
+		return "unknown"
+	case s.Git.CurrentBranch != s.Git.Branch:
+		return fmt.Sprintf("%s (want %s)", s.Git.CurrentBranch, s.Git.Branch)
+	}
+	return s.Git.CurrentBranch
+}
+
+func (s serviceStatus) changesColumn() string {
+	if s.Git == nil || !s.Git.CheckedOut || s.Git.Error != "" {
+		return ""
+	}
+	changes := []string{}
+	if s.Git.Dirty > 0 {
+		changes = append(changes, fmt.Sprintf("%d dirty", s.Git.Dirty))
+	}
+	if s.Git.Ahead > 0 {
+		changes = append(changes, fmt.Sprintf("%d ahead", s.Git.Ahead))
+	}
+	if s.Git.Behind > 0 {
+		changes = append(changes, fmt.Sprintf("%d behind", s.Git.Behind))
+	}
+	if len(changes) == 0 {
+		return "clean"
+	}
+	return strings.Join(changes, ", ")
+}
+
+func (s serviceStatus) lastCommitColumn() string {
+	if s.Git == nil {
+		return ""
+	}
+	// Long subjects would push the container columns off the screen
+	commit := []rune(s.Git.LastCommit)
+	if len(commit) > 40 {
+		return string(commit[:37]) + "..."
+	}
+	return string(commit)
+}
+
+func (s serviceStatus) stateColumn() string {
+	switch len(s.Containers) {
+	case 0:
+		return "not created"
+	case 1:
+		return s.Containers[0].State
+	}
+	running := 0
+	for _, c := range s.Containers {
+		if c.State == "running" {
+			running++
+		}
+	}
+	return fmt.Sprintf("running %d/%d", running, len(s.Containers))
+}
+
+// firstRunning returns the replica shown in the health and uptime columns
+func (s serviceStatus) firstRunning() containerStatus {
+	for _, c := range s.Containers {
+		if c.State == "running" {
+			return c
+		}
+	}
+	return containerStatus{}
+}
+
+// ports returns the ports published by all the replicas
+func (s serviceStatus) ports() []string {
+	ports := []string{}
+	for _, c := range s.Containers {
+		ports = append(ports, c.Ports...)
+	}
+	return ports
+}
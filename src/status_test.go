@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func helperStatusDcm(t *testing.T) (*Dcm, *fakeEngine, func()) {
+	dcm, _, dir := helperCreateTestDcm(t, "dcmtest", map[string]string{
+		"srv/dcmtest/ok/":                  "",
+		"srv/dcmtest/feature/":             "",
+		"srv/dcmtest/git_rev_parse_error/": "",
+	})
+
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	dcm.Engine = engine
+	dcm.Config.Settings.DefaultBranch = "develop"
+	repo := func(name string) yamlConfig {
+		return yamlConfig{"labels": yamlConfig{"dcm.repository": "git@github.com:dcm/" + name + ".git"}}
+	}
+	dcm.Config.Config = yamlConfig{
+		"ok":                  repo("ok"),
+		"feature":             repo("feature"),
+		"missing":             repo("missing"),
+		"git_rev_parse_error": repo("git_rev_parse_error"),
+		"docker_rm_error":     yamlConfig{"image": "postgres:16"},
+		"builder":             yamlConfig{"build": "./builder"},
+	}
+
+	return dcm, f, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dcm, _, cleanup := helperStatusDcm(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	dcm.Stdout = &out
+	code, err := dcm.Status()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SERVICE              SOURCE  BRANCH                  CHANGES            LAST COMMIT                               STATE        HEALTH   UPTIME  PORTS\n"+
+		"builder              build   -                       -                  -                                         not created  -        -       -\n"+
+		"docker_rm_error      image   -                       -                  -                                         running      -        2h0m    -\n"+
+		"feature              repo    feature (want develop)  2 dirty            abc1234 Fix the login form when the s...  not created  -        -       -\n"+
+		"git_rev_parse_error  repo    unknown                 -                  -                                         not created  -        -       -\n"+
+		"missing              repo    not checked out         -                  -                                         not created  -        -       -\n"+
+		"ok                   repo    develop                 1 ahead, 3 behind  abc1234 Fix the login form when the s...  running 2/3  healthy  2h0m    0.0.0.0:8080->80/tcp, [::]:8080->80/tcp\n"+
+		"service [git_rev_parse_error]: exit status 128: fatal: not a git repository\n",
+		out.String())
+
+	// Selected services only, as JSON
+	out.Reset()
+	code, err = dcm.Status("ok", "--output", "json")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	var statuses []serviceStatus
+	require.Nil(t, json.Unmarshal(out.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "ok", statuses[0].Name)
+	assert.Equal(t, sourceRepo, statuses[0].Source)
+	assert.Equal(t, &gitStatus{
+		CheckedOut:    true,
+		Branch:        "develop",
+		CurrentBranch: "develop",
+		Upstream:      "origin/develop",
+		Ahead:         1,
+		Behind:        3,
+		LastCommit:    "abc1234 Fix the login form when the session expires",
+	}, statuses[0].Git)
+	require.Len(t, statuses[0].Containers, 3)
+	c := statuses[0].Containers[0]
+	assert.Equal(t, "dcmtest_ok_1", c.Name)
+	assert.Equal(t, "running", c.State)
+	assert.Equal(t, "healthy", c.Health)
+	assert.Equal(t, "2h0m", c.Uptime)
+	assert.Equal(t, []string{"0.0.0.0:8080->80/tcp", "[::]:8080->80/tcp"}, c.Ports)
+	assert.Equal(t, containerStatus{Name: "dcmtest_ok_2", Index: 2, State: "exited", Ports: []string{}}, statuses[0].Containers[1])
+}
+
+func TestStatusErrors(t *testing.T) {
+	dcm, f, cleanup := helperStatusDcm(t)
+	defer cleanup()
+	dcm.Stdout = ioutil.Discard
+
+	code, err := dcm.Status("-o", "xml")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Invalid output format [xml], must be one of: table, json, yaml")
+
+	code, err = dcm.Status("--output")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Error parsing options: flag needs an argument: --output")
+
+	code, err = dcm.Status("unknown")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Unknown service [unknown]")
+
+	f.errors["GET /containers/dcmtest_ok_1/json"] = http.StatusInternalServerError
+	code, err = dcm.Status("ok")
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Error inspecting container [dcmtest_ok_1] for service [ok]: Error response from daemon: fake error for /containers/dcmtest_ok_1/json")
+
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	code, err = dcm.Status()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Error getting containers for service [builder]: Error response from daemon: fake error for /containers/json")
+}
+
+func TestFormatUptime(t *testing.T) {
+	for n, test := range []struct {
+		up     time.Duration
+		uptime string
+	}{
+		{42 * time.Second, "42s"},
+		{5*time.Minute + 10*time.Second, "5m"},
+		{2*time.Hour + 3*time.Minute, "2h3m"},
+		{50 * time.Hour, "2d2h"},
+	} {
+		assert.Equal(t, test.uptime, formatUptime(test.up), "[%d: %s] Incorrect uptime returned", n, test.up)
+	}
+}
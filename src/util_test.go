@@ -27,3 +27,19 @@ func TestGetMapVal(t *testing.T) {
 	assert.Equal(t, nil, getMapVal(fixture, "aaa", "bbb", "ccc", "ddd", "eee"))
 	assert.Equal(t, nil, getMapVal(fixture, "invalid", "key"))
 }
+
+func TestGetEditDistance(t *testing.T) {
+	assert.Equal(t, 0, getEditDistance("", ""))
+	assert.Equal(t, 3, getEditDistance("", "foo"))
+	assert.Equal(t, 3, getEditDistance("foo", ""))
+	assert.Equal(t, 0, getEditDistance("foo", "foo"))
+	assert.Equal(t, 1, getEditDistance("foo", "fob"))
+	assert.Equal(t, 2, getEditDistance("dcm.initscirpt", "dcm.initscript"))
+	assert.Equal(t, 3, getEditDistance("kitten", "sitting"))
+}
+
+func TestInSlice(t *testing.T) {
+	assert.True(t, inSlice("up", []string{"build", "up"}))
+	assert.False(t, inSlice("web", []string{"build", "up"}))
+	assert.False(t, inSlice("up", nil))
+}
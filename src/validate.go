@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type problem struct {
+	File, Service, Message string
+	Line                   int
+}
+
+func (p problem) String() string {
+	pos := p.File
+	if p.Line > 0 {
+		pos = fmt.Sprintf("%s:%d", p.File, p.Line)
+	}
+	if p.Service == "" {
+		return fmt.Sprintf("%s: %s", pos, p.Message)
+	}
+	return fmt.Sprintf("%s: service [%s]: %s", pos, p.Service, p.Message)
+}
+
+// position is the place of a key in one of the config files
+type position struct {
+	File string
+	Line int
+}
+
+type yamlPositions map[string]int
+
+// getYamlPositions indexes the line number of every mapping key in a YAML
+// document by its path, e.g. "services/web/labels/dcm.branch". It only
+// understands the block style YAML used in compose files, which is enough
+// to point at the problems found in the config.
+func getYamlPositions(content []byte) yamlPositions {
+	type key struct {
+		indent int
+		name   string
+	}
+
+	positions := yamlPositions{}
+	stack := []key{}
+	blockIndent := -1
+
+	path := func(name string) string {
+		names := make([]string, 0, len(stack)+1)
+		for _, k := range stack {
+			names = append(names, k.name)
+		}
+		return strings.Join(append(names, name), "/")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		indent := len(text) - len(trimmed)
+
+		if trimmed == "" {
+			continue
+		}
+		if blockIndent >= 0 {
+			if indent > blockIndent {
+				// Still inside a block scalar
+				continue
+			}
+			blockIndent = -1
+		}
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			// A sequence can be at the same indentation as its parent key
+			for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+				stack = stack[:len(stack)-1]
+			}
+
+			// Entries of list style labels and environment are indexed
+			// by their key, e.g. `- "dcm.branch=develop"`
+			if len(stack) > 0 {
+				switch stack[len(stack)-1].name {
+				case "labels", "environment":
+					item := strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'")
+					if item != "" {
+						positions[path(strings.SplitN(item, "=", 2)[0])] = line
+					}
+				}
+			}
+			continue
+		}
+
+		name, value, ok := splitYamlKey(trimmed)
+		if !ok {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		positions[path(name)] = line
+		stack = append(stack, key{indent, name})
+
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+
+	return positions
+}
+
+func splitYamlKey(text string) (string, string, bool) {
+	var name, rest string
+
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		name, rest = text[1:end+1], text[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(text, ": ")
+		switch {
+		case i >= 0:
+			name, rest = text[:i], text[i+1:]
+		case strings.HasSuffix(text, ":"):
+			name = text[:len(text)-1]
+		default:
+			return "", "", false
+		}
+		if name == "" || strings.ContainsAny(name[:1], "{[&*!|>%@`") {
+			return "", "", false
+		}
+	}
+
+	return name, strings.TrimSpace(rest), true
+}
+
+// Line returns the line of the given service key, trying both the layout of
+// compose files with a services section and the one without.
+func (p yamlPositions) Line(service string, keys ...string) int {
+	path := strings.Join(append([]string{service}, keys...), "/")
+	if line, ok := p["services/"+path]; ok {
+		return line
+	}
+	return p[path]
+}
+
+// configPositions indexes the keys of all the config files
+type configPositions struct {
+	files     []string
+	positions map[string]yamlPositions
+	lines     map[string][]string
+}
+
+func (c *Config) getPositions() configPositions {
+	p := configPositions{
+		files:     c.Files,
+		positions: map[string]yamlPositions{},
+		lines:     map[string][]string{},
+	}
+	// Positions are only used to make the output more helpful, so a file
+	// that can't be read simply ends up without line numbers
+	for _, file := range c.Files {
+		content, _ := ioutil.ReadFile(file)
+		p.positions[file] = getYamlPositions(content)
+		p.lines[file] = strings.Split(string(content), "\n")
+	}
+	return p
+}
+
+// Locate returns the position of a key in the last file setting it, which
+// is the file the merged value comes from.
+func (p configPositions) Locate(service string, keys ...string) position {
+	for i := len(p.files) - 1; i >= 0; i-- {
+		file := p.files[i]
+		if line := p.positions[file].Line(service, keys...); line > 0 {
+			return position{file, line}
+		}
+	}
+	if len(p.files) > 0 {
+		return position{p.files[0], 0}
+	}
+	return position{}
+}
+
+// Text returns the text of the line at the position
+func (p configPositions) Text(pos position) string {
+	lines := p.lines[pos.File]
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return lines[pos.Line-1]
+}
+
+func (d *Dcm) Validate() (int, error) {
+	code, err := d.validate()
+	if err != nil {
+		return code, err
+	}
+	fmt.Fprintf(d.Stdout, "No problems found in config file [%s].\n", d.Config.File)
+	return 0, nil
+}
+
+func (d *Dcm) validate() (int, error) {
+	problems := d.getConfigProblems()
+	if len(problems) == 0 {
+		return 0, nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(d.Stderr, p)
+	}
+	return 1, fmt.Errorf("Found %d problem(s) in config file [%s]", len(problems), d.Config.File)
+}
+
+func (d *Dcm) getConfigProblems() []problem {
+	// A problem is reported in the last file setting the key, as that's
+	// the one the merged value comes from
+	locate := d.Config.getPositions().Locate
+
+	problems := []problem{}
+	add := func(service string, pos position, format string, args ...interface{}) {
+		problems = append(problems, problem{
+			File:    pos.File,
+			Service: service,
+			Line:    pos.Line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	services := []string{}
+	for service := range d.Config.Config {
+		service, ok := service.(string)
+		if !ok {
+			add("", position{d.Config.File, 0}, "Service name [%v] must be a string", service)
+			continue
+		}
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		configs, ok := d.Config.Config[service].(yamlConfig)
+		if !ok {
+			add(service, locate(service), "Service config must be a mapping")
+			continue
+		}
+
+		labels, _ := getMapVal(configs, "labels").(yamlConfig)
+		names := []string{}
+		for name := range labels {
+			if name, ok := name.(string); ok && strings.HasPrefix(name, "dcm.") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			pos := locate(service, "labels", name)
+			if _, ok := dcmLabels[name]; !ok {
+				if suggestion := getClosestLabel(name); suggestion != "" {
+					add(service, pos, "Unknown label [%s], did you mean [%s]?", name, suggestion)
+				} else {
+					add(service, pos, "Unknown label [%s]", name)
+				}
+				continue
+			}
+			if _, err := getLabelValue(labels, name); err != nil {
+				add(service, pos, "%v", err)
+			}
+		}
+
+		_, hasImage := configs["image"]
+		_, hasBuild := configs["build"]
+		_, hasRepo := labels["dcm.repository"]
+		if !hasImage && !hasBuild && !hasRepo {
+			add(service, locate(service), "Service has neither [image], [build] nor [dcm.repository] defined")
+		}
+
+		// Init scripts can only be checked once the repository is checked out
+		dir := filepath.Join(d.Config.Srv, service)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		for _, name := range []string{"dcm.pre_initscript", "dcm.initscript"} {
+			script, ok := labels[name].(string)
+			if !ok {
+				continue
+			}
+			// Scripts run in the container are found in the container only
+			if name == "dcm.initscript" && labels["dcm.initscript_target"] == initTargetContainer {
+				continue
+			}
+			if !filepath.IsAbs(script) {
+				script = filepath.Join(dir, script)
+			}
+			if _, err := os.Stat(script); err != nil {
+				add(service, locate(service, "labels", name), "Script [%s] referenced by label [%s] not found", script, name)
+			}
+		}
+	}
+
+	groups := []string{}
+	for group := range d.Config.Settings.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		for _, service := range d.Config.Settings.Groups[group] {
+			if _, ok := d.Config.Config[service]; !ok {
+				add("", locate("x-dcm", "groups", group), "Group [%s] lists unknown service [%s]", group, service)
+			}
+		}
+	}
+
+	return problems
+}
+
+func getClosestLabel(name string) string {
+	closest, distance := "", 4
+	for label := range dcmLabels {
+		if dist := getEditDistance(name, label); dist < distance ||
+			(dist == distance && label < closest) {
+			closest, distance = label, dist
+		}
+	}
+	return closest
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var yamlFixtureValidate string = `
+version: "2"
+services:
+  api:
+    build: ./srv/project/api
+    depends_on:
+      - db
+    labels:
+      dcm.repository: git@github.com:username/api.git
+      dcm.initscirpt: "dcm/init.bash"
+      dcm.updateable: "no"
+  db:
+    image: postgres
+    environment:
+      POSTGRES_INIT: |
+        dcm.fake: not a key
+    labels:
+      dcm.branch: 1.10
+  web:
+    build: ./srv/project/web
+    labels:
+      com.example.owner: web-team
+      dcm.initscript: "dcm/missing.bash"
+      dcm.pre_initscript: "dcm/pre-init.bash"
+  worker:
+    labels:
+      "dcm.repository": git@github.com:username/worker.git
+      dcm.unknown_label_without_suggestion: "true"
+  worker-cron:
+    command: crond
+x-dcm:
+  groups:
+    billing: [api, payments]
+`
+
+func TestGetYamlPositions(t *testing.T) {
+	positions := getYamlPositions([]byte(yamlFixtureValidate))
+
+	assert.Equal(t, 3, positions["services"])
+	assert.Equal(t, 4, positions["services/api"])
+	assert.Equal(t, 6, positions["services/api/depends_on"])
+	assert.Equal(t, 8, positions["services/api/labels"])
+	assert.Equal(t, 10, positions["services/api/labels/dcm.initscirpt"])
+	assert.Equal(t, 12, positions["services/db"])
+	assert.Equal(t, 17, positions["services/db/labels"])
+	assert.Equal(t, 27, positions["services/worker/labels/dcm.repository"])
+	assert.Equal(t, 0, positions["services/db/environment/POSTGRES_INIT/dcm.fake"])
+
+	assert.Equal(t, 10, positions.Line("api", "labels", "dcm.initscirpt"))
+	assert.Equal(t, 0, positions.Line("api", "labels", "dcm.invalid"))
+	assert.Equal(t, 2, getYamlPositions([]byte("\nweb:\n  image: foo\n")).Line("web"))
+
+	positions = getYamlPositions([]byte(yamlFixtureListLabels))
+	assert.Equal(t, 6, positions.Line("api", "labels", "dcm.repository"))
+	assert.Equal(t, 7, positions.Line("api", "labels", "dcm.branch"))
+	assert.Equal(t, 8, positions.Line("api", "labels", "com.example.flag"))
+	assert.Equal(t, 11, positions.Line("api", "environment", "HOME"))
+	assert.Equal(t, 14, positions.Line("db", "labels"))
+}
+
+func TestSplitYamlKey(t *testing.T) {
+	fixtures := []struct {
+		text, name, value string
+		ok                bool
+	}{
+		{"image: postgres", "image", "postgres", true},
+		{"image: \"foo:bar\"", "image", "\"foo:bar\"", true},
+		{"labels:", "labels", "", true},
+		{"\"dcm.branch\": master", "dcm.branch", "master", true},
+		{"'dcm.branch':", "dcm.branch", "", true},
+		{"\"dcm.branch\" master", "", "", false},
+		{"\"dcm.branch", "", "", false},
+		{"{foo: bar}", "", "", false},
+		{"just a value", "", "", false},
+		{": value", "", "", false},
+	}
+
+	for n, test := range fixtures {
+		name, value, ok := splitYamlKey(test.text)
+		assert.Equal(t, test.ok, ok, "[%d: %s] Incorrect result returned", n, test.text)
+		assert.Equal(t, test.name, name, "[%d: %s] Incorrect key returned", n, test.text)
+		assert.Equal(t, test.value, value, "[%d: %s] Incorrect value returned", n, test.text)
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	assert.Equal(t, "dcm.yml:3: service [api]: Error", problem{"dcm.yml", "api", "Error", 3}.String())
+	assert.Equal(t, "dcm.yml: service [api]: Error", problem{"dcm.yml", "api", "Error", 0}.String())
+	assert.Equal(t, "dcm.yml: Error", problem{"dcm.yml", "", "Error", 0}.String())
+}
+
+func TestGetClosestLabel(t *testing.T) {
+	assert.Equal(t, "dcm.initscript", getClosestLabel("dcm.initscirpt"))
+	assert.Equal(t, "dcm.branch", getClosestLabel("dcm.brnch"))
+	assert.Equal(t, "", getClosestLabel("dcm.something_else"))
+}
+
+func TestValidate(t *testing.T) {
+	var out, errOut bytes.Buffer
+
+	file := helperCreateTestFile(t, "validate_yaml", yamlFixtureValidate)
+	defer os.Remove(file)
+	os.Setenv("DCM_CONFIG_FILE", file)
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+
+	srv, err := ioutil.TempDir("", "srv")
+	require.Nil(t, err)
+	defer os.RemoveAll(srv)
+	require.Nil(t, os.MkdirAll(srv+"/web/dcm", 0777))
+	require.Nil(t, ioutil.WriteFile(srv+"/web/dcm/pre-init.bash", []byte("#!/bin/bash"), 0777))
+	config.Srv = srv
+
+	dcm := NewDcm(config, []string{})
+	dcm.Stdout = &out
+	dcm.Stderr = &errOut
+
+	// Negative case: all the problems are reported at once
+	code, err := dcm.Validate()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Found 7 problem(s) in config file ["+file+"]")
+	assert.Equal(t, ""+
+		file+":10: service [api]: Unknown label [dcm.initscirpt], did you mean [dcm.initscript]?\n"+
+		file+":11: service [api]: Label [dcm.updateable] must be either true or false, got [no]\n"+
+		file+":18: service [db]: Label [dcm.branch] must be a string, put the value in quotes, got [1.1]\n"+
+		file+":23: service [web]: Script ["+srv+"/web/dcm/missing.bash] referenced by label [dcm.initscript] not found\n"+
+		file+":28: service [worker]: Unknown label [dcm.unknown_label_without_suggestion]\n"+
+		file+":29: service [worker-cron]: Service has neither [image], [build] nor [dcm.repository] defined\n"+
+		file+":33: Group [billing] lists unknown service [payments]\n",
+		errOut.String())
+	assert.Empty(t, out.String())
+
+	// Positive case: success
+	out.Reset()
+	dcm.Config.Config = yamlConfig{
+		"api": yamlConfig{
+			"labels": yamlConfig{
+				"dcm.repository": "git@github.com:username/api.git",
+				"dcm.updateable": false,
+			},
+		},
+		"db": yamlConfig{"image": "postgres"},
+		// Local builds need no repository
+		"tools": yamlConfig{"build": "./tools"},
+		// Scripts run in the container are not looked for in the checkout
+		"web": yamlConfig{
+			"image": "username/web",
+			"labels": yamlConfig{
+				"dcm.initscript":        "dcm/missing.bash",
+				"dcm.initscript_target": "container",
+			},
+		},
+	}
+	dcm.Config.Settings.Groups = nil
+	code, err = dcm.Validate()
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+	assert.Equal(t, "No problems found in config file ["+file+"].\n", out.String())
+}
+
+func TestValidateOverrideFiles(t *testing.T) {
+	var out, errOut bytes.Buffer
+
+	dir, err := ioutil.TempDir("", "dcm")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := dir + "/project.yml"
+	require.Nil(t, ioutil.WriteFile(file, []byte(yamlFixtureMainFile), 0644))
+	require.Nil(t, ioutil.WriteFile(dir+"/project.local.yml", []byte(""+
+		"services:\n"+
+		"  api:\n"+
+		"    labels:\n"+
+		"      dcm.brnch: develop\n"+
+		"  web:\n"+
+		"    command: nginx\n"), 0644))
+	os.Setenv("DCM_CONFIG_FILE", file)
+	config, err := NewConfigFile()
+	require.Nil(t, err)
+
+	dcm := NewDcm(config, []string{})
+	dcm.Stdout = &out
+	dcm.Stderr = &errOut
+
+	// Problems are reported in the file the merged value comes from
+	code, err := dcm.Validate()
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "Found 2 problem(s) in config file ["+file+"]")
+	assert.Equal(t, ""+
+		dir+"/project.local.yml:4: service [api]: Unknown label [dcm.brnch], did you mean [dcm.branch]?\n"+
+		dir+"/project.local.yml:5: service [web]: Service has neither [image], [build] nor [dcm.repository] defined\n",
+		errOut.String())
+}
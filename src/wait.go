@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// waitInterval is the time between two checks of a service that is
+	// not ready yet
+	waitInterval = time.Second
+	// waitCheckTimeout bounds each attempt to reach dcm.wait_for
+	waitCheckTimeout = 5 * time.Second
+)
+
+// runWait waits for the selected services to be ready, in dependency order,
+// so that the init scripts don't race against services still starting
+func (d *Dcm) runWait() (int, error) {
+	timeout := time.Duration(d.Config.Settings.WaitTimeout) * time.Second
+	if timeout == 0 {
+		return 0, nil
+	}
+	return d.doForEachService(func(d *Dcm, s *Service) (int, error) {
+		if err := d.waitForService(s, timeout); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	})
+}
+
+// waitForService checks the service until it is ready, or the timeout
+// expires. The error then tells what it was still waiting for.
+func (d *Dcm) waitForService(s *Service, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		reason, err := d.getNotReadyReason(s)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("Service [%s] not ready after %v: %s", s.Name, timeout, reason)
+		}
+		if !waiting {
+			fmt.Fprintln(d.Stdout, "Waiting for service:", s.Name, "...")
+			waiting = true
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// getNotReadyReason returns why the service is not ready yet, or an empty
+// string once all its containers are running and healthy, and its
+// dcm.wait_for responds. Failing to reach the docker daemon is an error, and
+// so is a container that exited with an error and won't be restarted.
+func (d *Dcm) getNotReadyReason(s *Service) (string, error) {
+	containers, err := d.getContainers(s.Name)
+	if err != nil {
+		return "", fmt.Errorf("Error getting containers for service [%s]: %v", s.Name, err)
+	}
+	if len(containers) == 0 {
+		// Up created no container for the service, like for the ones
+		// behind a compose profile, so there is nothing to wait for
+		return "", nil
+	}
+	engine, err := d.getEngine()
+	if err != nil {
+		return "", err
+	}
+	for _, c := range containers {
+		state, err := engine.InspectContainer(c.ID)
+		if err != nil {
+			return "", fmt.Errorf("Error inspecting container [%s] for service [%s]: %v", c.Name, s.Name, err)
+		}
+		if !c.Running {
+			// One-shot containers, like migrations, are done once they
+			// exited successfully, unless a healthcheck or dcm.wait_for
+			// tells otherwise
+			if c.State == "exited" && state.ExitCode == 0 && state.Health == nil && s.WaitFor == "" {
+				continue
+			}
+			// Waiting is pointless once a container failed for good
+			if c.State == "exited" && state.ExitCode != 0 && !state.WillRestart {
+				return "", fmt.Errorf("Service [%s] failed: container [%s] exited with code %d", s.Name, c.Name, state.ExitCode)
+			}
+			if c.State == "exited" {
+				return fmt.Sprintf("container [%s] exited with code %d", c.Name, state.ExitCode), nil
+			}
+			return fmt.Sprintf("container [%s] is %s", c.Name, c.State), nil
+		}
+		// Containers without a healthcheck are ready once running
+		if state.Health != nil && state.Health.Status != "healthy" {
+			return fmt.Sprintf("container [%s] is %s", c.Name, state.Health.Status), nil
+		}
+	}
+
+	if s.WaitFor != "" {
+		if err := checkWaitFor(s.WaitFor); err != nil {
+			return err.Error(), nil
+		}
+	}
+	return "", nil
+}
+
+// parseWaitFor reads a dcm.wait_for label, either a TCP address given as
+// host:port or tcp://host:port, or an HTTP(S) URL
+func parseWaitFor(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "tcp://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme != "tcp" && u.Scheme != "http" && u.Scheme != "https":
+		return nil, fmt.Errorf("unsupported scheme [%s]", u.Scheme)
+	case u.Hostname() == "":
+		return nil, fmt.Errorf("missing host")
+	case u.Scheme == "tcp" && u.Port() == "":
+		return nil, fmt.Errorf("missing port")
+	}
+	return u, nil
+}
+
+// checkWaitFor fails unless the TCP address accepts connections, or the
+// URL answers with a status below 400
+func checkWaitFor(target string) error {
+	u, err := parseWaitFor(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "tcp" {
+		conn, err := net.DialTimeout("tcp", u.Host, waitCheckTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{Timeout: waitCheckTimeout}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return nil
+}
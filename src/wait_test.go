@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func helperWaitDcm(t *testing.T) (*Dcm, *fakeEngine, *bytes.Buffer, func()) {
+	dcm, out, dir := helperCreateTestDcm(t, "dcmtest", nil)
+
+	f := newFakeEngine()
+	engine, server := helperEngine(t, f)
+	dcm.Engine = engine
+
+	interval := waitInterval
+	waitInterval = 10 * time.Millisecond
+	return dcm, f, out, func() {
+		waitInterval = interval
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWaitForService(t *testing.T) {
+	dcm, f, out, cleanup := helperWaitDcm(t)
+	defer cleanup()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	dcm.Config.Config = yamlConfig{
+		"ok":              yamlConfig{"image": "ok"},
+		"ok-worker":       yamlConfig{"image": "ok"},
+		"missing":         yamlConfig{"image": "missing"},
+		"docker_rm_error": yamlConfig{"image": "postgres:16"},
+		"failed_to_run_docker_exec": yamlConfig{
+			"image":  "api",
+			"labels": yamlConfig{"dcm.wait_for": unavailable.URL + "/health"},
+		},
+	}
+	timeout := 50 * time.Millisecond
+
+	fixtures := []struct {
+		name    string
+		service string
+		err     string
+	}{
+		{
+			name:    "Positive case: running without healthcheck",
+			service: "docker_rm_error",
+		},
+		{
+			name:    "Negative case: replica exited with an error, without restart policy",
+			service: "ok",
+			err:     "Service [ok] failed: container [dcmtest_ok_2] exited with code 1",
+		},
+		{
+			name:    "Positive case: no container started, like behind a profile",
+			service: "missing",
+		},
+		{
+			name:    "Negative case: wait_for not responding",
+			service: "failed_to_run_docker_exec",
+			err:     "Service [failed_to_run_docker_exec] not ready after 50ms: GET " + unavailable.URL + "/health: 503 Service Unavailable",
+		},
+	}
+
+	for n, test := range fixtures {
+		s, err := dcm.Config.Service(test.service)
+		require.Nil(t, err)
+		err = dcm.waitForService(s, timeout)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.name)
+		} else {
+			assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.name)
+		}
+	}
+
+	// Positive case: one-shot container exited successfully
+	f.Lock()
+	f.containers = append(f.containers, EngineContainer{
+		ID:    "dcmtest_migrate_1",
+		Names: []string{"/dcmtest_migrate_1"},
+		Labels: map[string]string{
+			composeProjectLabel: "dcmtest",
+			composeServiceLabel: "migrate",
+			composeNumberLabel:  "1",
+		},
+		State: "exited",
+	})
+	f.Unlock()
+	dcm.Config.Config["migrate"] = yamlConfig{"image": "api"}
+	s, err := dcm.Config.Service("migrate")
+	require.Nil(t, err)
+	assert.NoError(t, dcm.waitForService(s, timeout))
+
+	// Negative case: one-shot container with a dcm.wait_for to answer
+	s.WaitFor = unavailable.URL + "/health"
+	err = dcm.waitForService(s, timeout)
+	assert.EqualError(t, err, "Service [migrate] not ready after 50ms: container [dcmtest_migrate_1] exited with code 0")
+
+	// Negative case: a replica the daemon restarts is waited for
+	f.Lock()
+	f.restartPolicies["dcmtest_ok_2"] = "always"
+	f.Unlock()
+	s, err = dcm.Config.Service("ok")
+	require.Nil(t, err)
+	err = dcm.waitForService(s, timeout)
+	assert.EqualError(t, err, "Service [ok] not ready after 50ms: container [dcmtest_ok_2] exited with code 1")
+
+	// Positive case: waits for the healthcheck to pass
+	f.Lock()
+	f.health["dcmtest_ok-worker_1"] = "starting"
+	f.Unlock()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		f.Lock()
+		f.health["dcmtest_ok-worker_1"] = "healthy"
+		f.Unlock()
+	}()
+	out.Reset()
+	s, err = dcm.Config.Service("ok-worker")
+	require.Nil(t, err)
+	assert.NoError(t, dcm.waitForService(s, time.Second))
+	assert.Equal(t, "Waiting for service: ok-worker ...\n", out.String())
+
+	// Negative case: unhealthy until the timeout
+	f.Lock()
+	f.health["dcmtest_ok-worker_1"] = "unhealthy"
+	f.Unlock()
+	err = dcm.waitForService(s, timeout)
+	assert.EqualError(t, err, "Service [ok-worker] not ready after 50ms: container [dcmtest_ok-worker_1] is unhealthy")
+
+	// Negative case: the daemon fails
+	f.Lock()
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	f.Unlock()
+	err = dcm.waitForService(s, timeout)
+	assert.EqualError(t, err, "Error getting containers for service [ok-worker]: Error response from daemon: fake error for /containers/json")
+}
+
+func TestRunWait(t *testing.T) {
+	dcm, f, _, cleanup := helperWaitDcm(t)
+	defer cleanup()
+
+	dcm.Config.Config = yamlConfig{
+		"ok":              yamlConfig{"image": "ok"},
+		"docker_rm_error": yamlConfig{"image": "postgres:16"},
+		"ok-worker":       yamlConfig{"image": "ok", "depends_on": []interface{}{"docker_rm_error"}},
+	}
+
+	// Positive case: only the selected services are waited for
+	dcm.Selector = selector{Services: []string{"ok-worker"}}
+	code, err := dcm.Run("wait")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+
+	// Positive case: no wait at all with a zero timeout
+	f.Lock()
+	f.errors["GET /containers/json"] = http.StatusInternalServerError
+	f.Unlock()
+	dcm.Config.Settings.WaitTimeout = 0
+	dcm.Selector = selector{}
+	code, err = dcm.Run("wait")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+}
+
+func TestParseWaitFor(t *testing.T) {
+	for n, test := range []struct {
+		value, url, err string
+	}{
+		{value: "localhost:5432", url: "tcp://localhost:5432"},
+		{value: "tcp://db:5432", url: "tcp://db:5432"},
+		{value: "http://localhost:8080/health", url: "http://localhost:8080/health"},
+		{value: "https://api.local", url: "https://api.local"},
+		{value: "ftp://db:21", err: "unsupported scheme [ftp]"},
+		{value: "http:///health", err: "missing host"},
+		{value: "localhost", err: "missing port"},
+	} {
+		u, err := parseWaitFor(test.value)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "[%d: %s] Incorrect error returned", n, test.value)
+			continue
+		}
+		assert.NoError(t, err, "[%d: %s] Non-nil error returned", n, test.value)
+		assert.Equal(t, test.url, u.String(), "[%d: %s] Incorrect URL returned", n, test.value)
+	}
+}
+
+func TestCheckWaitFor(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	assert.NoError(t, checkWaitFor(listener.Addr().String()))
+	listener.Close()
+	assert.Error(t, checkWaitFor(listener.Addr().String()))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	assert.NoError(t, checkWaitFor(server.URL+"/health"))
+	assert.EqualError(t, checkWaitFor(server.URL+"/missing"), "GET "+server.URL+"/missing: 404 Not Found")
+}